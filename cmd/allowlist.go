@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// filterAllowed returns the given IPs within the CIDR ranges of the allowlist in config, all of them if no allowlist.
+// Skipped IPs are warned about, as they may come from poisoned DNS answers.
+func filterAllowed(IPs []probe.ResolvedIP) []probe.ResolvedIP {
+	if len(config.Hunt.Allowlist) == 0 {
+		return IPs
+	}
+	networks, err := parseNetworks(config.Hunt.Allowlist)
+	if err != nil {
+		panic(fmt.Errorf("invalid allowlist entry: %w", err))
+	}
+
+	r := make([]probe.ResolvedIP, 0, len(IPs))
+	for _, IP := range IPs {
+		if !containsIP(networks, IP.IP) {
+			fmt.Fprintf(os.Stderr, "Warning: skipped %s, not in the allowlist.\n", IP.String())
+			continue
+		}
+		r = append(r, IP)
+	}
+	return r
+}
+
+// parseNetworks parses the given CIDR ranges, or single IPs.
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, s := range entries {
+		if !strings.Contains(s, "/") { // single IP
+			if IP := net.ParseIP(s); IP != nil && IP.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("\"%s\": %w", s, err)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// containsIP returns whether any of the given networks contains the given IP.
+func containsIP(networks []*net.IPNet, IP net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// blacklistCmd represents the blacklist command
+var blacklistCmd = &cobra.Command{
+	Use:   "blacklist [flags]",
+	Short: "Show or reset the IPs that consistently serve censored content",
+	Long: `Show or reset the IPs that consistently serve censored content. 
+IPs that served censored content in at least "hunt.blacklist_threshold" (in config) hunts are skipped in future hunts. 
+Example: weibo-image-hound blacklist --reset`,
+	Run: blacklist,
+}
+
+func init() {
+	rootCmd.AddCommand(blacklistCmd)
+	blacklistCmd.Flags().BoolP("reset", "r", false, "reset the censored counts of all IPs")
+}
+
+func blacklist(cmd *cobra.Command, args []string) {
+	if cmd.Flag("reset").Changed {
+		config.Cache.Censored = nil
+		saveConfig()
+		fmt.Println("Blacklist reset.")
+		return
+	}
+
+	threshold := config.Hunt.BlacklistThreshold
+	if threshold <= 0 {
+		fmt.Println("Blacklisting is disabled, set \"hunt.blacklist_threshold\" in config to enable it.")
+	}
+	for IP, count := range config.Cache.Censored {
+		if threshold > 0 && count >= threshold {
+			fmt.Printf("[BLACKLISTED] %s | %d\n", IP, count)
+		} else {
+			fmt.Printf("%s | %d\n", IP, count)
+		}
+	}
+}
+
+// filterBlacklisted returns the given IPs without the blacklisted ones.
+func filterBlacklisted(IPs []probe.ResolvedIP) []probe.ResolvedIP {
+	threshold := config.Hunt.BlacklistThreshold
+	if threshold <= 0 || len(config.Cache.Censored) == 0 {
+		return IPs
+	}
+	r := make([]probe.ResolvedIP, 0, len(IPs))
+	for _, IP := range IPs {
+		if config.Cache.Censored[IP.String()] < threshold {
+			r = append(r, IP)
+		}
+	}
+	if skipped := len(IPs) - len(r); skipped > 0 {
+		fmt.Printf("Skipped %d blacklisted resolves.\n", skipped)
+	}
+	return r
+}
+
+// recordCensored increments the censored counts of the given IPs, and resets the counts of the winner IPs.
+func recordCensored(IPs map[string]struct{}, winners ...probe.ResolvedIP) {
+	if config.Cache.Censored == nil {
+		config.Cache.Censored = make(map[string]int)
+	}
+	for IP := range IPs {
+		config.Cache.Censored[IP]++
+	}
+	for _, IP := range winners {
+		delete(config.Cache.Censored, IP.String())
+	}
+}
@@ -1,14 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
-	"sync"
+	"slices"
+	"sort"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"weibo-image-hound/internal/probe"
+	"weibo-image-hound/internal/probe/dns"
+	"weibo-image-hound/internal/probe/fallback"
 	"weibo-image-hound/internal/probe/globalping"
 	"weibo-image-hound/internal/weibo"
 )
@@ -18,62 +23,372 @@ var cacheCmd = &cobra.Command{
 	Use:   "cache [flags]",
 	Short: "Cache resolved IP addresses for all Weibo image hostnames",
 	Long: `Cache resolved IP addresses for all Weibo image hostnames. 
+The locations that returned IPs are recorded per provider, and only those are resolved from in the next runs. 
 Example: weibo-image-hound cache -p globalping -f`,
 	Run: cache,
 }
 
 func init() {
 	rootCmd.AddCommand(cacheCmd)
-	cacheCmd.Flags().StringP("provider", "p", "globalping", "probe provider to use")
+	cacheCmd.PersistentFlags().StringP("provider", "p", "globalping", "probe provider to use (globalping, dns of \"providers.dns.resolvers\" in config, or fallback of \"providers.fallback\" in config)")
 	cacheCmd.Flags().BoolP("force", "f", false, "force overwrite existing cached resolves")
+	cacheCmd.Flags().StringSlice("measurement", nil, "cache the resolves of the existing GlobalPing measurements of the given IDs instead of creating new ones")
+	cacheCmd.Flags().Bool("verify", false, "discard the resolved IPs outside the prefixes and ASNs of \"verify\" in config, e.g. from poisoned DNS answers")
+	cacheCmd.Flags().Bool("dry-run", false, "only print the planned measurements and the number of probes they would use, without creating them")
+	cacheCmd.Flags().Bool("include-local", false, "also resolve with the local resolver, tagging its IPs as local in the cache")
+	cacheCmd.Flags().StringSlice("location", nil, "locations to resolve from (default: the ones that returned IPs in the previous runs with the same provider, or all)")
+	cacheCmd.Flags().Bool("all-locations", false, "resolve from all locations of the provider, including the ones that returned no IPs in the previous runs")
+	cacheCmd.Flags().Bool("productive-only", false, "only resolve from the locations that returned IPs in the previous runs with the same provider")
+	_ = cacheCmd.Flags().MarkDeprecated("productive-only", "it's now the default, use --all-locations to resolve from all")
+	cacheCmd.Flags().Bool("incremental", false, "only resolve the hostnames last resolved longer than --ttl ago, adding to the cached resolves")
+	cacheCmd.Flags().Duration("ttl", 24*time.Hour, "how long the resolves of a hostname are fresh for with --incremental")
+	cacheCmd.Flags().Int("rotate", 0, "only resolve from the given number of locations, rotating through all of them across runs")
+	cacheCmd.PersistentFlags().String("dump-raw", "", "dump raw measurement results to the given file (\"-\" for stderr)")
+	cacheCmd.PersistentFlags().Lookup("dump-raw").NoOptDefVal = "-"
+	cacheCmd.PersistentFlags().String("tag", "", "label attached to the created measurements, to identify them later (default from config)")
 }
 
 func cache(cmd *cobra.Command, args []string) {
-	var provider probe.Provider
-	name := cmd.Flag("provider").Value.String()
-	switch name {
-	case "globalping":
-		provider = globalping.NewClient()
-	default:
-		panic(fmt.Errorf("unknown provider: %s", name))
+	provider, closeProvider := newProvider(cmd)
+	defer closeProvider()
+
+	if cmd.Flag("measurement").Changed {
+		cacheMeasurements(cmd, provider)
+		return
 	}
 
 	// cache resolves
-	locations, err := provider.Locations()
-	if err != nil {
-		panic(fmt.Errorf("failed to get locations: %w", err))
+	providerName := cmd.Flag("provider").Value.String()
+	var locations []string
+	if cmd.Flag("location").Changed {
+		locations, _ = cmd.Flags().GetStringSlice("location")
+		locations = unique(locations)
+	} else {
+		all, err := provider.Locations()
+		if err != nil {
+			panic(fmt.Errorf("failed to get locations: %w", err))
+		}
+		locations = unique(all)
+		if !cmd.Flag("all-locations").Changed {
+			locations = productiveLocations(providerName, locations)
+		}
+	}
+	if n, _ := cmd.Flags().GetInt("rotate"); n > 0 {
+		locations = rotateLocations(locations, n)
+	}
+	hostnames := weibo.Hostnames()
+	if cmd.Flag("incremental").Changed {
+		if cmd.Flag("force").Changed {
+			panic(fmt.Errorf("--incremental doesn't work with --force"))
+		}
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		if hostnames = staleHostnames(hostnames, ttl); len(hostnames) == 0 {
+			fmt.Println("All hostnames are still fresh, nothing to resolve.")
+			return
+		}
 	}
-	locations = unique(locations)
 	fmt.Printf("Using %d locations.\n", len(locations))
+	if cmd.Flag("dry-run").Changed {
+		printPlan(provider, locations)
+		return
+	}
 
-	hostnames := weibo.Hostnames()
-	var wg sync.WaitGroup
-	ch := make(chan []net.IP, len(hostnames))
+	byHostname := resolveHostnames(provider, hostnames, locations)
+	var resolved []probe.ResolvedIP
+	for _, h := range hostnames {
+		resolved = append(resolved, byHostname[h]...)
+	}
+	if cmd.Flag("include-local").Changed {
+		resolved = append(resolved, resolveLocally(cmd.Context())...)
+	}
+	recordProductive(providerName, locations, resolved)
+	if cmd.Flag("verify").Changed {
+		resolved = verifyResolves(cmd.Context(), resolved)
+	}
+	cacheResolves(resolved, byHostname, cmd.Flag("force").Changed, len(locations))
+}
+
+// printPlan prints how all Weibo image hostnames would be resolved from the given locations.
+func printPlan(provider probe.Provider, locations []string) {
+	p, ok := provider.(interface {
+		Plan(hostname string, locations []string) (string, int)
+	})
+	total := 0
+	for _, h := range weibo.Hostnames() {
+		if !ok {
+			fmt.Printf("%s: from %d locations\n", h, len(locations))
+			continue
+		}
+		plan, probes := p.Plan(h, locations)
+		fmt.Printf("%s: %s\n", h, plan)
+		total += probes
+	}
+	if ok {
+		fmt.Printf("Up to %d probes in total, each counting towards the rate limit of the provider.\n", total)
+	}
+}
+
+// resolveHostnames resolves the given hostnames from the given locations concurrently,
+// and returns the resolved IPs of the ones resolved successfully, by hostname.
+func resolveHostnames(provider probe.Provider, hostnames []string, locations []string) map[string][]probe.ResolvedIP {
+	ch := make(chan resolveResult, len(hostnames))
 	for _, h := range hostnames {
-		wg.Add(1)
 		go func(hostname string) {
-			defer wg.Done()
-			IPs, err := provider.Resolve(hostname, locations)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to resolve \"%s\": %v\n", hostname, err)
-				ch <- nil
-				return
-			}
-			ch <- IPs
+			resolved, err := provider.Resolve(hostname, locations)
+			ch <- resolveResult{hostname: hostname, resolved: resolved, err: err}
 		}(h)
 	}
-	wg.Wait()
 
+	byHostname := make(map[string][]probe.ResolvedIP, len(hostnames))
+	for range hostnames { // report each hostname as soon as it's resolved
+		r := <-ch
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to resolve \"%s\": %v\n", r.hostname, r.err)
+			continue
+		}
+		fmt.Printf("Resolved %s: %d IPs from %d answers.\n", r.hostname, len(probe.Unique(r.resolved)), len(r.resolved))
+		byHostname[r.hostname] = r.resolved
+	}
+	return byHostname
+}
+
+// recordResolved records the given hostnames as resolved now in the cache, only the ones with any of their resolved IPs
+// among the cached ones, so the ones without any (e.g. all discarded by --verify) stay stale.
+func recordResolved(byHostname map[string][]probe.ResolvedIP, cached []probe.ResolvedIP) {
+	isCached := make(map[string]bool, len(cached))
+	for _, r := range cached {
+		isCached[r.IP.String()] = true
+	}
+	for h, resolved := range byHostname {
+		if !slices.ContainsFunc(resolved, func(r probe.ResolvedIP) bool { return isCached[r.IP.String()] }) {
+			continue
+		}
+		if config.Cache.Resolved == nil {
+			config.Cache.Resolved = make(map[string]time.Time)
+		}
+		config.Cache.Resolved[h] = time.Now()
+	}
+}
+
+// staleHostnames returns the given hostnames last resolved longer than ttl ago (or never),
+// and reports the skipped fresh ones.
+func staleHostnames(hostnames []string, ttl time.Duration) []string {
+	stale := make([]string, 0, len(hostnames))
+	for _, h := range hostnames {
+		if t, ok := config.Cache.Resolved[h]; ok && time.Since(t) < ttl {
+			fmt.Printf("Skipped %s, still fresh (resolved %s ago).\n", h, time.Since(t).Round(time.Second))
+			continue
+		}
+		stale = append(stale, h)
+	}
+	return stale
+}
+
+// resolveLocally resolves all Weibo image hostnames with the local resolver.
+func resolveLocally(ctx context.Context) []probe.ResolvedIP {
+	var resolved []probe.ResolvedIP
+	for _, h := range weibo.Hostnames() {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, h)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to resolve \"%s\" locally: %v\n", h, err)
+			continue
+		}
+		for _, a := range addrs {
+			resolved = append(resolved, probe.ResolvedIP{IP: a.IP, Local: true})
+		}
+		fmt.Printf("Resolved %s locally: %d IPs.\n", h, len(addrs))
+	}
+	return resolved
+}
+
+// cacheMeasurements caches the resolves of the existing measurements given by the flags of cmd.
+func cacheMeasurements(cmd *cobra.Command, provider probe.Provider) {
+	m, ok := provider.(interface {
+		Measurement(ID string) ([]probe.ResolvedIP, error)
+	})
+	if !ok {
+		panic(fmt.Errorf("--measurement only works with the globalping provider"))
+	}
+	IDs, _ := cmd.Flags().GetStringSlice("measurement")
+	var resolved []probe.ResolvedIP
+	for _, ID := range IDs {
+		r, err := m.Measurement(ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fetch measurement %s: %v\n", ID, err)
+			continue
+		}
+		fmt.Printf("Fetched measurement %s: %d IPs from %d answers.\n", ID, len(probe.Unique(r)), len(r))
+		resolved = append(resolved, r...)
+	}
+	if cmd.Flag("verify").Changed {
+		resolved = verifyResolves(cmd.Context(), resolved)
+	}
+	cacheResolves(resolved, nil, cmd.Flag("force").Changed, 0)
+}
+
+// cacheResolves adds the given resolved IPs to the cached resolves (replacing them if forced),
+// records the given hostnames they were resolved for (if known) as resolved,
+// and reports their diversity across the given number of locations.
+func cacheResolves(resolved []probe.ResolvedIP, byHostname map[string][]probe.ResolvedIP, force bool, numLocations int) {
 	resolves := config.Cache.Resolves
-	if cmd.Flag("force").Changed { // force overwrite
+	if force { // force overwrite
 		resolves = nil
 	}
-	for range hostnames {
-		resolves = append(resolves, <-ch...)
-	}
-	config.Cache.Resolves = uniqueIPs(resolves)
+	recordResolved(byHostname, resolved)
+	config.Cache.Resolves = probe.Unique(append(resolves, resolved...))
 	saveConfig()
+	pending.flush() // only after their results are saved
 	fmt.Printf("Cached %d resolves.\n", len(config.Cache.Resolves))
+	reportDiversity(probe.IPs(resolved), numLocations)
+}
+
+// newProvider returns the probe provider specified by the flags of cmd, and a function to release its resources.
+func newProvider(cmd *cobra.Command) (probe.Provider, func()) {
+	closer := func() {}
+	pending.load(cfgFilePath)
+	opts := append(config.Providers.GlobalPing.Options(), globalping.WithTracker(pending))
+	if cmd.Flag("tag").Changed {
+		opts = append(opts, globalping.WithTag(cmd.Flag("tag").Value.String()))
+	}
+	if dumpPath := cmd.Flag("dump-raw").Value.String(); dumpPath == "-" {
+		opts = append(opts, globalping.WithRawDump(os.Stderr))
+	} else if dumpPath != "" {
+		f, err := os.Create(dumpPath)
+		if err != nil {
+			panic(fmt.Errorf("failed to create raw dump file: %w", err))
+		}
+		closer = func() { f.Close() }
+		opts = append(opts, globalping.WithRawDump(f))
+	}
+	provider, err := providerByName(cmd.Flag("provider").Value.String(), opts)
+	if err != nil {
+		panic(err)
+	}
+	return provider, closer
+}
+
+// providerByName returns the probe provider of the given name, with the given GlobalPing client options.
+func providerByName(name string, opts []globalping.Option) (probe.Provider, error) {
+	switch name {
+	case "globalping":
+		return globalping.NewClient(opts...), nil
+	case "dns":
+		return dns.New(config.Providers.DNS), nil
+	case "fallback":
+		names := config.Providers.Fallback
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no providers configured in \"providers.fallback\"")
+		}
+		providers := make([]probe.Provider, 0, len(names))
+		for _, n := range names {
+			if n == "fallback" {
+				return nil, fmt.Errorf("fallback provider can't include itself")
+			}
+			p, err := providerByName(n, opts)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, p)
+		}
+		return fallback.New(names, providers), nil
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+}
+
+// rotateLocations returns the next n of the given locations in the rotation, and advances the rotation.
+func rotateLocations(locations []string, n int) []string {
+	if n >= len(locations) {
+		return locations
+	}
+	sort.Strings(locations) // keep a stable order across runs
+	offset := config.Cache.RotationOffset % len(locations)
+	r := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		r = append(r, locations[(offset+i)%len(locations)])
+	}
+	config.Cache.RotationOffset = (offset + n) % len(locations)
+	fmt.Printf("Rotating locations, %d to %d of %d.\n", offset+1, (offset+n-1)%len(locations)+1, len(locations))
+	return r
+}
+
+// productiveLocations returns the given locations that returned IPs in the previous runs with the named provider,
+// or all of them if none of them were recorded.
+func productiveLocations(providerName string, locations []string) []string {
+	productive := config.Cache.Locations[providerName]
+	if len(productive) == 0 { // not recorded yet, e.g. by an older version
+		return locations
+	}
+	r := make([]string, 0, len(productive))
+	for _, l := range locations {
+		if slices.Contains(productive, l) {
+			r = append(r, l)
+		}
+	}
+	if len(r) == 0 { // all gone from the provider
+		fmt.Println("None of the productive locations recorded are available any more, using all.")
+		return locations
+	}
+	fmt.Printf("Using %d productive locations of %d recorded in the previous runs (--all-locations to use all).\n", len(r), len(locations))
+	return r
+}
+
+// recordProductive records which of the queried locations returned IPs with the named provider into the cache,
+// keeping the records of the locations not queried this time.
+// Only resolved IPs telling their regions count, so nothing is recorded for providers not telling them.
+func recordProductive(providerName string, queried []string, resolved []probe.ResolvedIP) {
+	var productive []string
+	for _, l := range config.Cache.Locations[providerName] {
+		if !slices.Contains(queried, l) {
+			productive = append(productive, l)
+		}
+	}
+	known := false
+	for _, r := range resolved {
+		for _, region := range r.Regions {
+			known = true
+			if slices.Contains(queried, region) && !slices.Contains(productive, region) {
+				productive = append(productive, region)
+			}
+		}
+	}
+	if !known { // e.g. all failed
+		return
+	}
+	sort.Strings(productive)
+	if config.Cache.Locations == nil {
+		config.Cache.Locations = make(map[string][]string)
+	}
+	config.Cache.Locations[providerName] = productive
+}
+
+// resolveResult represents the result of resolving a hostname.
+type resolveResult struct {
+	hostname string
+	resolved []probe.ResolvedIP
+	err      error
+}
+
+// reportDiversity prints the network-level diversity of the given resolved IPs,
+// and warns when the answers from many locations collapse into only a few networks.
+func reportDiversity(IPs []net.IP, numLocations int) {
+	unique := uniqueIPs(IPs)
+	networks := make(map[string]struct{}, len(unique))
+	for _, IP := range unique {
+		networks[networkOf(IP)] = struct{}{}
+	}
+	fmt.Printf("Got %d answers, %d unique IPs in %d networks.\n", len(IPs), len(unique), len(networks))
+	if numLocations > 0 && len(networks)*2 < numLocations {
+		fmt.Fprintf(os.Stderr, "Warning: %d locations collapsed into only %d networks, the effective coverage is low.\n", numLocations, len(networks))
+	}
+}
+
+// networkOf returns the network (/24 for IPv4, /48 for IPv6) the given IP belongs to, in CIDR notation.
+func networkOf(IP net.IP) string {
+	if IP4 := IP.To4(); IP4 != nil {
+		return (&net.IPNet{IP: IP4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: IP.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
 }
 
 // unique returns a new slice containing only the unique elements of the given slice.
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"weibo-image-hound/internal/hound"
+)
+
+// certInfo is a certificate in the chain captured by `hunt --capture-certs`.
+type certInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	SANs      []string  `json:"sans,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	SHA256    string    `json:"sha256"`
+}
+
+// edgeCerts is the certificate chain presented by an edge.
+type edgeCerts struct {
+	Address string     `json:"address"`
+	Chain   []certInfo `json:"chain"`
+}
+
+// captureCerts records the certificate chain presented by the edge of the given result with --capture-certs,
+// once per edge in a hunt.
+func (h *hunter) captureCerts(result hound.Result) {
+	if !h.cmd.Flag("capture-certs").Changed || len(result.Certificates) == 0 {
+		return
+	}
+	addr := net.JoinHostPort(result.IP.String(), result.Port)
+	for _, e := range h.certs {
+		if e.Address == addr {
+			return
+		}
+	}
+	chain := make([]certInfo, 0, len(result.Certificates))
+	for _, c := range result.Certificates {
+		chain = append(chain, certInfoOf(c))
+	}
+	h.certs = append(h.certs, edgeCerts{Address: addr, Chain: chain})
+}
+
+// certInfoOf returns the info of the given certificate.
+func certInfoOf(c *x509.Certificate) certInfo {
+	sum := sha256.Sum256(c.Raw)
+	SANs := append([]string(nil), c.DNSNames...)
+	for _, IP := range c.IPAddresses {
+		SANs = append(SANs, IP.String())
+	}
+	return certInfo{
+		Subject:   c.Subject.String(),
+		Issuer:    c.Issuer.String(),
+		SANs:      SANs,
+		NotBefore: c.NotBefore,
+		NotAfter:  c.NotAfter,
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+}
+
+// printCerts prints the distinct leaf certificates presented by the given edges, with the edges presenting each.
+func printCerts(edges []edgeCerts) {
+	var leaves []certInfo
+	presenters := make(map[string][]string) // leaf fingerprint -> addresses
+	for _, e := range edges {
+		leaf := e.Chain[0]
+		if _, ok := presenters[leaf.SHA256]; !ok {
+			leaves = append(leaves, leaf)
+		}
+		presenters[leaf.SHA256] = append(presenters[leaf.SHA256], e.Address)
+	}
+	fmt.Printf("Captured %d distinct certificates from %d edges:\n", len(leaves), len(edges))
+	for _, c := range leaves {
+		fmt.Printf("  %s | issued by %s | SANs: %s | valid %s to %s | sha256 %s\n", c.Subject, c.Issuer, strings.Join(c.SANs, ", "),
+			c.NotBefore.Format(time.DateOnly), c.NotAfter.Format(time.DateOnly), c.SHA256)
+		fmt.Printf("    presented by %s\n", strings.Join(presenters[c.SHA256], ", "))
+	}
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import "os"
+
+var noColor bool
+
+const (
+	colorReset = "\x1b[0m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+)
+
+// tagColors are the colors of the status tags.
+var tagColors = map[string]string{
+	"[SUCCESS]": colorGreen,
+	"[FAILED]":  colorRed,
+}
+
+// colorTag returns the given status tag (e.g. "[SUCCESS]") in its color if the output to f is colored,
+// which is only to a terminal, without the --no-color flag or the NO_COLOR environment variable.
+func colorTag(f *os.File, tag string) string {
+	color, ok := tagColors[tag]
+	if !ok || noColor || os.Getenv("NO_COLOR") != "" || !isTerminal(f) {
+		return tag
+	}
+	return color + tag + colorReset
+}
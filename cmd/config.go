@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+// configShowCmd represents the config show command
+var configShowCmd = &cobra.Command{
+	Use:   "show [flags]",
+	Short: "Show the effective configuration",
+	Long: `Show the effective configuration, with secrets redacted. 
+Flags of other commands are not reflected, as they only apply to their own runs. 
+Example: weibo-image-hound config show --profile asia -f json`,
+	Run: showConfig,
+}
+
+const redacted = "<redacted>"
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+	configShowCmd.Flags().StringP("format", "f", "yaml", "output format: yaml|json")
+}
+
+func showConfig(cmd *cobra.Command, args []string) {
+	c := *config
+	if len(c.Hunt.Cookies) > 0 {
+		c.Hunt.Cookies = make(map[string]string, len(config.Hunt.Cookies))
+		for name := range config.Hunt.Cookies {
+			c.Hunt.Cookies[name] = redacted
+		}
+	}
+
+	var b []byte
+	var err error
+	switch format := cmd.Flag("format").Value.String(); format {
+	case "yaml":
+		fmt.Printf("# config file: %s\n", cfgFilePath)
+		if profileName != "" {
+			fmt.Printf("# cache and providers from profile: %s\n", profileName)
+		}
+		b, err = yaml.Marshal(&c)
+	case "json": // converted from YAML to keep the same keys
+		var v interface{}
+		if b, err = yaml.Marshal(&c); err == nil {
+			if err = yaml.Unmarshal(b, &v); err == nil {
+				b, err = json.MarshalIndent(struct {
+					File    string      `json:"file"`
+					Profile string      `json:"profile,omitempty"`
+					Config  interface{} `json:"config"`
+				}{cfgFilePath, profileName, v}, "", "  ")
+				b = append(b, '\n')
+			}
+		}
+	default:
+		panic(fmt.Errorf("unknown format: %s", format))
+	}
+	if err != nil {
+		panic(fmt.Errorf("failed to marshal config: %w", err))
+	}
+	fmt.Print(string(b))
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+
+	"weibo-image-hound/internal/hound"
+	"weibo-image-hound/internal/probe"
+)
+
+// connectReport is the reachability of an IP in the report of `hunt --connect-only`.
+type connectReport struct {
+	Address     string  `json:"address"`
+	Reachable   bool    `json:"reachable"`
+	ConnectMs   float64 `json:"connect_ms,omitempty"`
+	HandshakeMs float64 `json:"handshake_ms,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// connect only connects to the given IPs on the given ports for the host of u (and performs the TLS handshake for HTTPS),
+// and prints the reachability report, without sending any HTTP request.
+func (h *hunter) connect(u *url.URL, ports []string, IPs []probe.ResolvedIP) error {
+	serverName := ""
+	if u.Scheme == "https" {
+		serverName = u.Hostname()
+	}
+	ctx, cancel := context.WithCancel(h.cmd.Context())
+	defer cancel()
+	n := len(IPs) * len(ports)
+	ch := make(chan hound.ConnectResult, n)
+	go hound.Connect(ctx, ch, serverName, ports, IPs, h.opts)
+
+	reports := make([]connectReport, 0, n)
+	reachable := 0
+	for i := 0; i < n; i++ {
+		r := <-ch
+		report := connectReport{Address: net.JoinHostPort(r.IP.String(), r.Port), Reachable: r.Err == nil}
+		if r.Connect > 0 {
+			report.ConnectMs = float64(r.Connect.Microseconds()) / 1000
+		}
+		if r.Handshake > 0 {
+			report.HandshakeMs = float64(r.Handshake.Microseconds()) / 1000
+		}
+		if r.Err != nil {
+			report.Error = r.Err.Error()
+		} else {
+			reachable++
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Address < reports[j].Address })
+
+	if h.cmd.Flag("json").Changed {
+		b, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		fmt.Println(string(b))
+	} else {
+		for _, r := range reports {
+			switch {
+			case r.Error != "":
+				fmt.Fprintf(os.Stderr, "%s %s | %s\n", colorTag(os.Stderr, "[FAILED]"), r.Address, r.Error)
+			case r.HandshakeMs > 0:
+				fmt.Printf("%s %s | connect %.1fms | handshake %.1fms\n", colorTag(os.Stdout, "[SUCCESS]"), r.Address, r.ConnectMs, r.HandshakeMs)
+			default:
+				fmt.Printf("%s %s | connect %.1fms\n", colorTag(os.Stdout, "[SUCCESS]"), r.Address, r.ConnectMs)
+			}
+		}
+		fmt.Printf("%d of %d reachable.\n", reachable, n)
+	}
+	if reachable == 0 {
+		return errAllFailed
+	}
+	return nil
+}
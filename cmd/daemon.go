@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"weibo-image-hound/internal/probe"
+	"weibo-image-hound/internal/weibo"
+)
+
+// daemonCmd represents the cache daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon [flags]",
+	Short: "Keep refreshing the cached resolves periodically",
+	Long: `Keep refreshing the cached resolves periodically. 
+Each refresh re-reads the config and the cache, resolves all Weibo image hostnames again, 
+replaces the cached resolves with the new ones, and logs the IPs added and removed. 
+Everything else in the config and the cache is kept as read, including the changes made by other runs meanwhile. 
+A refresh failing to resolve any IP keeps the previous resolves. 
+Example: weibo-image-hound cache daemon --interval 6h`,
+	Run: daemon,
+}
+
+func init() {
+	cacheCmd.AddCommand(daemonCmd)
+	daemonCmd.Flags().Duration("interval", time.Hour, "interval between refreshes")
+}
+
+func daemon(cmd *cobra.Command, args []string) {
+	interval, _ := cmd.Flags().GetDuration("interval")
+	if interval <= 0 {
+		panic(fmt.Errorf("invalid interval: %s", interval))
+	}
+	provider, closeProvider := newProvider(cmd)
+	defer closeProvider()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		refresh(provider)
+		fmt.Printf("Next refresh at %s.\n", time.Now().Add(interval).Format(time.DateTime))
+		select {
+		case <-ticker.C:
+		case <-cmd.Context().Done():
+			return
+		}
+	}
+}
+
+// refresh replaces the cached resolves with newly resolved ones, and logs the difference.
+// The config is re-read first, so only the resolves and when they were resolved are changed in the one saved.
+func refresh(provider probe.Provider) {
+	fmt.Printf("[%s] Refreshing.\n", time.Now().Format(time.DateTime))
+	loadConfig()
+	locations, err := provider.Locations()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get locations: %v\n", err)
+		return
+	}
+	byHostname := resolveHostnames(provider, weibo.Hostnames(), unique(locations))
+	var resolved []probe.ResolvedIP
+	for _, h := range weibo.Hostnames() {
+		resolved = append(resolved, byHostname[h]...)
+	}
+	resolved = probe.Unique(resolved)
+	if len(resolved) == 0 {
+		fmt.Fprintln(os.Stderr, "No IPs resolved, keeping the previous resolves.")
+		return
+	}
+
+	added, removed := diffIPs(probe.IPs(config.Cache.Resolves), probe.IPs(resolved))
+	config.Cache.Resolves = resolved // swap in as a whole
+	recordResolved(byHostname, resolved)
+	saveConfig()
+	pending.flush()
+	fmt.Printf("[%s] Cached %d resolves, %d added, %d removed.\n", time.Now().Format(time.DateTime), len(resolved), len(added), len(removed))
+	for _, IP := range added {
+		fmt.Printf("  + %s\n", IP)
+	}
+	for _, IP := range removed {
+		fmt.Printf("  - %s\n", IP)
+	}
+}
+
+// diffIPs returns the IPs in b but not in a, and the ones in a but not in b, sorted.
+func diffIPs(a []net.IP, b []net.IP) (added []string, removed []string) {
+	inA := make(map[string]struct{}, len(a))
+	for _, IP := range a {
+		inA[IP.String()] = struct{}{}
+	}
+	inB := make(map[string]struct{}, len(b))
+	for _, IP := range b {
+		inB[IP.String()] = struct{}{}
+		if _, ok := inA[IP.String()]; !ok {
+			added = append(added, IP.String())
+		}
+	}
+	for IP := range inA {
+		if _, ok := inB[IP]; !ok {
+			removed = append(removed, IP)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"weibo-image-hound/internal/hound"
+)
+
+// attempt is the outcome of a request to an address, recorded with `hunt --explain` or into the history.
+type attempt struct {
+	Address   string   `json:"address"`
+	Outcome   string   `json:"outcome"`
+	Size      int      `json:"size,omitempty"` // of the body, if OK
+	Redirects []string `json:"redirects,omitempty"`
+}
+
+// recordAttempt records the outcome of the given result, with the error returned by check for it,
+// with --explain or "hunt.history_attempts" in config.
+func (h *hunter) recordAttempt(result hound.Result, err error) {
+	if !h.cmd.Flag("explain").Changed && !config.Hunt.HistoryAttempts {
+		return
+	}
+	a := attempt{Address: net.JoinHostPort(result.IP.String(), result.Port), Outcome: outcomeOf(result, err), Redirects: result.Redirects}
+	if err == nil {
+		a.Size = len(result.Body)
+	}
+	h.attempts = append(h.attempts, a)
+}
+
+// outcomeOf returns the class of the outcome of the given result, with the error returned by check for it.
+func outcomeOf(result hound.Result, err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "OK"
+	case errors.Is(result.Err, hound.ErrConnect):
+		return "connect failed"
+	case errors.Is(result.Err, hound.ErrTLSHandshake):
+		return "TLS handshake failed"
+	case errors.Is(result.Err, hound.ErrBodyTooLarge):
+		return "body too large"
+	case errors.As(result.Err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case result.Err != nil:
+		return "request failed"
+	}
+	return err.Error() // e.g. HTTP status, placeholder
+}
+
+// explain prints the outcomes of all attempts recorded in the current hunt, per IP and overall,
+// so the pattern of a total failure can be seen.
+func (h *hunter) explain() {
+	if !h.cmd.Flag("explain").Changed || len(h.attempts) == 0 {
+		return
+	}
+	type tally struct {
+		counts map[string]int
+		order  []string // outcomes in the order first seen
+	}
+	byAddr := make(map[string]*tally)
+	overall := &tally{counts: make(map[string]int)}
+	for _, a := range h.attempts {
+		t, ok := byAddr[a.Address]
+		if !ok {
+			t = &tally{counts: make(map[string]int)}
+			byAddr[a.Address] = t
+		}
+		for _, t := range []*tally{t, overall} {
+			if t.counts[a.Outcome] == 0 {
+				t.order = append(t.order, a.Outcome)
+			}
+			t.counts[a.Outcome]++
+		}
+	}
+	format := func(t *tally) string {
+		parts := make([]string, 0, len(t.order))
+		for _, o := range t.order {
+			parts = append(parts, fmt.Sprintf("%s x%d", o, t.counts[o]))
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	addrs := make([]string, 0, len(byAddr))
+	for addr := range byAddr {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	fmt.Printf("[EXPLAIN] %d attempts on %d addresses: %s\n", len(h.attempts), len(addrs), format(overall))
+	for _, addr := range addrs {
+		fmt.Printf("[EXPLAIN] %s | %s\n", addr, format(byAddr[addr]))
+	}
+}
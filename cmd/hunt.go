@@ -1,132 +1,973 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"io"
+	"math/rand"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"weibo-image-hound/internal/hound"
+	"weibo-image-hound/internal/meta"
+	"weibo-image-hound/internal/probe"
 	"weibo-image-hound/internal/weibo"
 )
 
 // huntCmd represents the hunt command
 var huntCmd = &cobra.Command{
-	Use:   "hunt [URL] [flags]",
+	Use:   "hunt [URL]... [flags]",
 	Short: "Hunt for an uncensored Weibo image, given its URL",
 	Long: `Hunt for an uncensored Weibo image, given its URL. 
-Example: weibo-image-hound hunt https://wx4.sinaimg.cn/mw2000/c49cf6fdgy1hjwxqm5ctrj20k04zytjs.jpg`,
-	Run: hunt,
+Multiple URLs can be given to hunt for them one by one, in which case the output path must be a directory. 
+Example: weibo-image-hound hunt https://wx4.sinaimg.cn/mw2000/c49cf6fdgy1hjwxqm5ctrj20k04zytjs.jpg 
+Example: pbpaste | weibo-image-hound hunt --from-html - -o images/`,
+	RunE: hunt,
 }
 
+const (
+	defaultFileMode os.FileMode = 0644
+	defaultDirMode  os.FileMode = 0755
+)
+
 func init() {
 	rootCmd.AddCommand(huntCmd)
-	huntCmd.Flags().StringP("output", "o", "", "output file path (default: current directory, auto filename)")
+	huntCmd.Flags().StringP("output", "o", "", "output file path, also e.g. /dev/stdout or /dev/fd/3 (default: current directory, auto filename)")
+	huntCmd.Flags().Int("output-fd", -1, "write the image to the given open file descriptor instead of a file, e.g. 3 for process substitution (informational output is moved to stderr for 1)")
+	huntCmd.Flags().Bool("output-stdout-meta", false, "print the result of each hunt as a JSON line to stderr (the same as in the history file), while saving the image as usual")
+	huntCmd.Flags().String("file-mode", "", "octal permission of the output files and sidecars (default from config, or 644 with the umask applied)")
+	huntCmd.Flags().String("dir-mode", "", "octal permission of the created output directories (default from config, or 755 with the umask applied)")
+	huntCmd.Flags().Bool("detect-watermark", false, "warn when the found image is likely watermarked")
+	huntCmd.Flags().String("cookie-file", "", "Netscape cookie file to load Weibo session cookies from (default from config)")
+	huntCmd.Flags().StringToString("cookie", nil, "cookie to send, as name=value (repeatable, overrides the config)")
+	huntCmd.Flags().Bool("no-cookies", false, "don't send any cookies")
+	huntCmd.Flags().IntSlice("status-codes", nil, "HTTP status codes counted as a hit (default from config, or 200)")
+	huntCmd.Flags().Bool("embed-metadata", false, "embed the recovery metadata into the saved image (JPEG, PNG), or write a sidecar file for other formats")
+	huntCmd.Flags().Bool("preview", false, "render a small preview of the found image in the terminal")
+	huntCmd.Flags().Bool("stream", false, "stream the found image directly to the output file instead of buffering it in memory")
+	huntCmd.Flags().Int("peek", 0, "peek the first given KiB of the image from all resolves to find the best one before fully downloading it")
+	huntCmd.Flags().Lookup("peek").NoOptDefVal = "64"
+	huntCmd.Flags().Duration("header-timeout", 0, "timeout for receiving the response headers from each resolve")
+	huntCmd.Flags().Int("max-redirects", 0, "maximum number of redirects to follow, to the same resolve (default: not following any)")
+	huntCmd.Flags().Bool("http1-fallback", false, "retry the requests failed by an HTTP/2 error (e.g. GOAWAY) once over HTTP/1.1")
+	huntCmd.Flags().Duration("connect-timeout", 0, "timeout for establishing the TCP connection to each resolve (default: the overall timeout)")
+	huntCmd.Flags().Duration("tls-timeout", 0, "timeout for the TLS handshake with each resolve after connected")
+	huntCmd.Flags().Int64("min-rate", 0, "minimum transfer rate in KiB/s, replacing the overall request timeout (for large images)")
+	huntCmd.Flags().String("tls-mimic", "go", "TLS ClientHello profile to mimic: "+strings.Join(hound.TLSProfiles, "|"))
+	huntCmd.Flags().Duration("hedge-delay", 0, "request from the resolves one after another, starting the next one when the in-flight ones don't respond within the delay (default: all at once)")
+	huntCmd.Flags().Bool("try-all-hosts", false, "on total failure, retry the hunt on each of the other known CDN hostnames in turn (with --rotate-persona, as each persona)")
+	huntCmd.Flags().Bool("rotate-persona", false, "on total failure, retry the hunt as each of the browser personas (User-Agent and TLS fingerprint) in turn: "+strings.Join(personaNames(), ", "))
+	huntCmd.Flags().Bool("retry-handshake", false, "retry the resolves failed at the TLS handshake once with a fresh connection")
+	huntCmd.Flags().StringSlice("ports", nil, "ports to try on each resolve (default: the port of the URL)")
+	huntCmd.Flags().StringSlice("from-country", nil, "only use the cached resolves resolved from the given countries (ISO 3166-1 alpha-2 codes, e.g. HK)")
+	huntCmd.Flags().StringSlice("from-region", nil, "only use the cached resolves resolved from the given regions (case-insensitive, e.g. \"Western Europe\")")
+	huntCmd.Flags().Duration("ip-spacing", 0, "minimum interval between the requests to the same resolve, e.g. across qualities, for edges rate-limiting per source")
+	huntCmd.Flags().Duration("round-delay", 0, "average delay between the rounds of qualities, randomly jittered by ±50% to look less like automated traffic")
+	huntCmd.Flags().String("order", "config", "order to try the cached resolves in, one of: "+strings.Join(orderNames(), ", "))
+	huntCmd.Flags().Bool("shuffle", false, "try the cached resolves in random order")
+	_ = huntCmd.Flags().MarkDeprecated("shuffle", "use --order random instead")
+	huntCmd.Flags().Int64("seed", 0, "seed of all randomization in the hunt, for reproducible runs (default: time-based)")
+	huntCmd.Flags().Int("expect-width", 0, "only accept an image of the given width in pixels")
+	huntCmd.Flags().Int("expect-height", 0, "only accept an image of the given height in pixels")
+	huntCmd.Flags().Int("expect-tolerance", 0, "tolerance in pixels of --expect-width and --expect-height")
+	huntCmd.Flags().String("resolve-host", "", "hostname to send as Host and SNI to the cached resolves instead of the one of the URL, e.g. a CDN alias")
+	huntCmd.Flags().Int64("max-body-size", 0, "maximum size in KiB of a response body read into memory, larger ones are failures (default 65536, or max_body_size in config)")
+	huntCmd.Flags().Int64("max-bytes", 0, "budget of the total bytes received from all resolves in the run, after which the remaining requests and URLs are given up (default: unlimited)")
+	huntCmd.Flags().String("user", "", "user:password to authenticate with by HTTP basic auth, e.g. to an authenticated reverse proxy")
+	huntCmd.Flags().String("bearer", "", "token to authenticate with by the HTTP bearer auth, e.g. to an authenticated reverse proxy")
+	huntCmd.Flags().Bool("skip-existing", false, "skip the images already in the library, by the image ID in the filenames, or by content after downloaded")
+	huntCmd.Flags().String("library", "", "directory of saved images checked with --skip-existing (default: the output directory)")
+	huntCmd.Flags().Bool("explain", false, "on total failure, print the outcomes of all attempts per resolve")
+	huntCmd.Flags().Bool("capture-certs", false, "capture the TLS certificate chain presented by each edge into the report (printed, and in the history file)")
+	huntCmd.Flags().Bool("compare-schemes", false, "request every resolve over both HTTPS (port 443) and plain HTTP (port 80), and report which served the image")
+	huntCmd.Flags().String("prefer", "highest", "which result to prefer: highest (hunt for the qualities one by one, highest first) or first (hunt for all qualities at once, taking the first hit of any quality)")
+	huntCmd.Flags().Int("min-upgrade-tiers", 0, "only count a hunt as successful when the recovered quality is at least the given number of tiers higher than the one of the URL (see `qualities`)")
+	huntCmd.Flags().Bool("all-qualities", false, "save every recoverable quality to a separate file (suffixed with the quality) instead of only the highest one")
+	huntCmd.Flags().Bool("connect-only", false, "only connect (and perform the TLS handshake for HTTPS) to the cached resolves to report their reachability, without any HTTP request")
+	huntCmd.Flags().Bool("json", false, "print the report of --connect-only, or why there are no usable cached resolves, as JSON")
+	huntCmd.Flags().String("from-html", "", "hunt for all images in the given HTML file (\"-\" for stdin), e.g. a snippet of a Weibo page, in addition to the given URLs")
+	huntCmd.Flags().String("csv", "", "write a CSV report of all hunted URLs to the given file (\"-\" for stdout)")
+	huntCmd.Flags().Bool("fail-fast", false, "stop and exit with non-zero code on the first failed URL (default: continue with the rest)")
+	huntCmd.Flags().StringP("strategy", "s", "first", "strategy to select the result among successful ones: "+strings.Join(hound.Strategies, "|"))
 }
 
-func hunt(cmd *cobra.Command, args []string) {
-	if len(args) != 1 {
-		_ = cmd.Help()
-		return
+func hunt(cmd *cobra.Command, args []string) error {
+	if path := cmd.Flag("from-html").Value.String(); path != "" {
+		URLs, err := readHTMLURLs(path)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Found %d image URLs in the HTML.\n", len(URLs))
+		args = append(args, URLs...)
+	}
+	if len(args) == 0 {
+		return cmd.Help()
 	}
 
-	dir, filename, err := parseOutputPath(cmd.Flag("output").Value.String())
+	fileMode, err := parseFileMode(config.Hunt.FileMode, cmd.Flag("file-mode"), defaultFileMode)
+	if err != nil {
+		panic(fmt.Errorf("invalid file mode: %w", err))
+	}
+	dirMode, err := parseFileMode(config.Hunt.DirMode, cmd.Flag("dir-mode"), defaultDirMode)
+	if err != nil {
+		panic(fmt.Errorf("invalid directory mode: %w", err))
+	}
+	dir, filename, err := parseOutputPath(cmd.Flag("output").Value.String(), dirMode)
 	if err != nil {
 		panic(fmt.Errorf("failed to parse output path: %w", err))
 	}
+	if len(args) > 1 && filename != "." && filename != "/" {
+		panic(fmt.Errorf("output path must be a directory when hunting multiple URLs"))
+	}
+	if cmd.Flag("all-qualities").Changed && filename != "." && filename != "/" {
+		panic(fmt.Errorf("output path must be a directory when saving all qualities"))
+	}
+	outFile, err := openOutputFD(cmd, len(args))
+	if err != nil {
+		panic(err)
+	}
+	if outFile != nil {
+		defer outFile.Close()
+	}
 
-	URL := args[0]
-	u, err := parseURL(URL)
+	preferred := filterAllowed(config.Cache.PreferredIPs) // user-curated, always tried first
+	IPs := exceptIPs(config.Cache.Resolves, preferred)
+	if len(IPs) == 0 && len(preferred) == 0 {
+		return noResolves(cmd, "no_cache", "No cached resolves found, please run `weibo-image-hound cache` first")
+	}
+	if IPs = filterBlacklisted(IPs); len(IPs) == 0 && len(preferred) == 0 {
+		return noResolves(cmd, "all_blacklisted", "All cached resolves are blacklisted, please run `weibo-image-hound blacklist --reset` first")
+	}
+	if IPs = filterAllowed(IPs); len(IPs) == 0 && len(preferred) == 0 {
+		return noResolves(cmd, "none_allowed", "No cached resolves are in the allowlist")
+	}
+	if cmd.Flag("from-country").Changed {
+		countries, _ := cmd.Flags().GetStringSlice("from-country")
+		if IPs = filterPlaces(IPs, countries, func(r probe.ResolvedIP) []string { return r.Countries }); len(IPs) == 0 && len(preferred) == 0 {
+			return noResolves(cmd, "none_from_countries", "No cached resolves from the given countries, please run `weibo-image-hound cache` to record where they are resolved from")
+		}
+	}
+	if cmd.Flag("from-region").Changed {
+		regions, _ := cmd.Flags().GetStringSlice("from-region")
+		if IPs = filterPlaces(IPs, regions, func(r probe.ResolvedIP) []string { return r.Regions }); len(IPs) == 0 && len(preferred) == 0 {
+			return noResolves(cmd, "none_from_regions", "No cached resolves from the given regions, please run `weibo-image-hound cache` to record where they are resolved from")
+		}
+	}
+	if len(preferred) > 0 {
+		fmt.Printf("Using %d cached resolves, after %d preferred ones.\n", len(IPs), len(preferred))
+	} else {
+		fmt.Printf("Using %d cached resolves.\n", len(IPs))
+	}
+
+	var opts hound.Options
+	if !cmd.Flag("no-cookies").Changed {
+		cookieFile := config.Hunt.CookieFile
+		if cmd.Flag("cookie-file").Changed {
+			cookieFile = cmd.Flag("cookie-file").Value.String()
+		}
+		cookies := config.Hunt.Cookies
+		if cmd.Flag("cookie").Changed {
+			cookies, _ = cmd.Flags().GetStringToString("cookie")
+		}
+		if cookieFile != "" || len(cookies) > 0 {
+			if opts.Jar, err = hound.NewCookieJar(cookieFile, cookies); err != nil {
+				panic(fmt.Errorf("failed to load cookies: %w", err))
+			}
+		}
+	}
+
+	headers, err := authHeaders(cmd)
 	if err != nil {
-		panic(fmt.Errorf("invalid URL: %w", err))
+		panic(err)
+	}
+	if _, err = hound.NewSelector(cmd.Flag("strategy").Value.String()); err != nil {
+		panic(err)
+	}
+	opts.TLSProfile = cmd.Flag("tls-mimic").Value.String()
+	if !slices.Contains(hound.TLSProfiles, opts.TLSProfile) {
+		panic(fmt.Errorf("unknown TLS profile: %s", opts.TLSProfile))
+	}
+	opts.HeaderTimeout, _ = cmd.Flags().GetDuration("header-timeout")
+	opts.ConnectTimeout, _ = cmd.Flags().GetDuration("connect-timeout")
+	opts.TLSHandshakeTimeout, _ = cmd.Flags().GetDuration("tls-timeout")
+	opts.RetryHandshake = cmd.Flag("retry-handshake").Changed
+	opts.HTTP1Fallback = cmd.Flag("http1-fallback").Changed
+	opts.MaxRedirects, _ = cmd.Flags().GetInt("max-redirects")
+	if opts.MaxRedirects < 0 {
+		panic(fmt.Errorf("invalid maximum redirects: %d", opts.MaxRedirects))
+	}
+	opts.HedgeDelay, _ = cmd.Flags().GetDuration("hedge-delay")
+	if spacing, _ := cmd.Flags().GetDuration("ip-spacing"); spacing > 0 {
+		opts.Spacer = hound.NewSpacer(spacing)
+	}
+	if minRate, _ := cmd.Flags().GetInt64("min-rate"); minRate > 0 {
+		opts.MinRate = minRate * 1024
+	}
+	opts.MaxBodySize = config.Hunt.MaxBodySize * 1024
+	if cmd.Flag("max-body-size").Changed {
+		maxBodySize, _ := cmd.Flags().GetInt64("max-body-size")
+		opts.MaxBodySize = maxBodySize * 1024
+	}
+	if maxBytes, _ := cmd.Flags().GetInt64("max-bytes"); maxBytes > 0 {
+		opts.Budget = hound.NewBudget(maxBytes)
+	}
+	opts.StatusCodes, opts.PlaceholderHashes = config.Hunt.StatusCodes, config.Hunt.PlaceholderHashes
+	if cmd.Flag("status-codes").Changed {
+		opts.StatusCodes, _ = cmd.Flags().GetIntSlice("status-codes")
+	}
+	if opts.Stream = cmd.Flag("stream").Changed; opts.Stream {
+		// the other modes need the full body in memory
+		if cmd.Flag("strategy").Value.String() != "first" {
+			panic(fmt.Errorf("--stream only works with the \"first\" strategy"))
+		}
+		if cmd.Flag("detect-watermark").Changed || cmd.Flag("embed-metadata").Changed {
+			panic(fmt.Errorf("--stream doesn't work with --detect-watermark or --embed-metadata"))
+		}
+		if cmd.Flag("expect-width").Changed || cmd.Flag("expect-height").Changed {
+			panic(fmt.Errorf("--stream doesn't work with --expect-width or --expect-height"))
+		}
+		if cmd.Flag("compare-schemes").Changed {
+			panic(fmt.Errorf("--stream doesn't work with --compare-schemes"))
+		}
 	}
 
-	IPs := config.Cache.Resolves
-	if len(IPs) == 0 {
-		fmt.Println("No cached resolves found, please run `weibo-image-hound cache` first")
-		return
+	if cmd.Flag("try-all-hosts").Changed && cmd.Flag("resolve-host").Changed {
+		panic(fmt.Errorf("--try-all-hosts doesn't work with --resolve-host"))
+	}
+	switch cmd.Flag("prefer").Value.String() {
+	case "highest":
+	case "first":
+		if cmd.Flag("all-qualities").Changed || cmd.Flag("compare-schemes").Changed || cmd.Flag("peek").Changed {
+			panic(fmt.Errorf("--prefer first doesn't work with --all-qualities, --compare-schemes or --peek"))
+		}
+	default:
+		panic(fmt.Errorf("unknown preference: %s", cmd.Flag("prefer").Value.String()))
+	}
+
+	if minTiers, _ := cmd.Flags().GetInt("min-upgrade-tiers"); minTiers < 0 {
+		panic(fmt.Errorf("invalid minimum upgrade tiers: %d", minTiers))
+	}
+
+	seed := time.Now().UnixNano()
+	if cmd.Flag("seed").Changed {
+		seed, _ = cmd.Flags().GetInt64("seed")
+	}
+	order := cmd.Flag("order").Value.String()
+	if cmd.Flag("shuffle").Changed {
+		order = "random"
+	}
+	if _, ok := orderers[order]; !ok {
+		panic(fmt.Errorf("unknown order: %s", order))
+	}
+	if order == "random" || cmd.Flag("round-delay").Changed {
+		fmt.Printf("Using random seed %d.\n", seed)
+	}
+
+	h := &hunter{
+		cmd:       cmd,
+		rand:      rand.New(rand.NewSource(seed)),
+		opts:      opts,
+		order:     orderers[order],
+		headers:   headers,
+		IPs:       IPs,
+		preferred: preferred,
+		dir:       dir,
+		filename:  filename,
+		outFile:   outFile,
+		fileMode:  fileMode,
+		censored:  make(map[string]struct{}),
+	}
+	h.opts.IsHit = h.isHit
+	if cmd.Flag("skip-existing").Changed {
+		dir := cmd.Flag("library").Value.String()
+		if dir == "" {
+			dir = h.dir
+		}
+		if h.library, err = loadLibrary(dir); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Found %d images in the library at %s.\n", len(h.library.paths), dir)
+	}
+	hunted, failed, skipped := 0, 0, 0
+	for _, URL := range args {
+		hunted++
+		if err = h.hunt(URL); errors.Is(err, errSkipped) {
+			skipped++
+		} else if errors.Is(err, hound.ErrBudgetExceeded) {
+			fmt.Fprintf(os.Stderr, "%s %s | %v\n", colorTag(os.Stderr, "[FAILED]"), URL, err)
+			failed++
+			break
+		} else if err != nil {
+			if !errors.Is(err, errAllFailed) {
+				fmt.Fprintf(os.Stderr, "%s %s | %v\n", colorTag(os.Stderr, "[FAILED]"), URL, err)
+			}
+			failed++
+			if cmd.Flag("fail-fast").Changed {
+				break
+			}
+		}
+	}
+	recordCensored(h.censored, h.winners...)
+	saveConfig()
+	if path := cmd.Flag("csv").Value.String(); path != "" {
+		if err = writeCSV(path, h.reports); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write CSV: %v\n", err)
+		}
+	}
+	if config.Hunt.HistoryFile != "" {
+		if err = appendHistory(config.Hunt.HistoryFile, h.reports); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write history: %v\n", err)
+		}
+	}
+	if path := historyDBPath(); path != "" {
+		if err = insertHistory(path, h.reports); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write history: %v\n", err)
+		}
+	}
+	if h.opts.Budget.Exceeded() {
+		fmt.Printf("Gave up after receiving %d bytes, over the budget of %d bytes.\n", h.opts.Budget.Used(), h.opts.Budget.Limit())
+	}
+	if len(args) > 1 {
+		if skipped > 0 {
+			fmt.Printf("Hunted %d URLs, %d failed, %d skipped as already in the library.\n", hunted, failed, skipped)
+		} else {
+			fmt.Printf("Hunted %d URLs, %d failed.\n", hunted, failed)
+		}
+	}
+	if failed > 0 && cmd.Flag("fail-fast").Changed {
+		return exitWith(cmd, 1)
+	}
+	return nil
+}
+
+// exitNoResolves is the exit code of hunt when there are no usable cached resolves, e.g. before running `cache`,
+// as opposed to 1 of failed hunts with --fail-fast.
+const exitNoResolves = 3
+
+// noResolves prints why there are no usable cached resolves, as an object of the given error code and the message
+// with --json, and returns the error exiting with exitNoResolves.
+func noResolves(cmd *cobra.Command, code string, msg string) error {
+	if cmd.Flag("json").Changed {
+		b, _ := json.Marshal(struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}{code, msg})
+		msg = string(b)
+	}
+	fmt.Println(msg)
+	return exitWith(cmd, exitNoResolves)
+}
+
+// huntRound is a round of hunting for all qualities, of the rounds retried with --rotate-persona and --try-all-hosts.
+type huntRound struct {
+	persona  persona // of the flags if empty
+	hostname string  // replacing the one of the URL, if not empty
+}
+
+// describe returns how the round differs from the first one, e.g. " on wx2.sinaimg.cn as the chrome-android persona".
+func (r huntRound) describe() string {
+	var s string
+	if r.hostname != "" {
+		s += " on " + r.hostname
+	}
+	if r.persona.name != "" {
+		s += " as the " + r.persona.name + " persona"
+	}
+	return s
+}
+
+// errAllFailed is returned by hunter.hunt when all resolves failed for all qualities.
+var errAllFailed = errors.New("all resolves failed")
+
+// errInsufficientUpgrade is returned by hunter.hunt when the recovered quality is not enough tiers higher than the one
+// of the URL, with --min-upgrade-tiers.
+var errInsufficientUpgrade = errors.New("insufficient upgrade")
+
+// errSkipped is returned by hunter.hunt when the image is already in the library, with --skip-existing.
+var errSkipped = errors.New("already in the library")
+
+// hunter holds the settings and state shared by the hunts of all given URLs.
+type hunter struct {
+	cmd       *cobra.Command
+	rand      *rand.Rand // source of all randomization, seeded by --seed
+	opts      hound.Options
+	headers   http.Header // extra request headers, e.g. of authentication
+	IPs       []probe.ResolvedIP
+	preferred []probe.ResolvedIP                                                         // tried before IPs, regardless of --order
+	order     func(h *hunter, IPs []probe.ResolvedIP, ports []string) []probe.ResolvedIP // orders IPs before each hunt, see orderers
+	latencies map[string]time.Duration                                                   // connect times by IP, measured once per ports with --order latency
+	latencyOn string                                                                     // the ports the latencies were measured on, comma-separated
+	successes map[string]int                                                             // successful hunts by IP in the history, with --order success
+	dir       string
+	filename  string
+	outFile   *os.File // the file descriptor given by --output-fd, written to instead of a file
+	fileMode  os.FileMode
+	censored  map[string]struct{} // IPs that served censored content
+	winners   []probe.ResolvedIP
+	certs     []edgeCerts // presented by the edges in the current hunt, with --capture-certs
+	library   *library    // with --skip-existing
+	attempts  []attempt   // outcomes of all requests in the current hunt, with --explain or a history file
+	paths     []string    // of the files saved in the current hunt
+	reports   []huntReport
+}
+
+// hunt hunts for the image of the given URL, and saves it to the output path.
+func (h *hunter) hunt(URL string) (err error) {
+	start := time.Now()
+	report := huntReport{URL: URL, Time: start}
+	h.certs, h.attempts, h.paths = nil, nil, nil
+	defer func() {
+		report.Duration, report.Err = time.Since(start), err
+		report.Attempts, report.Paths = h.attempts, h.paths
+		if report.Certs = h.certs; len(h.certs) > 0 {
+			printCerts(h.certs)
+		}
+		h.reports = append(h.reports, report)
+		if h.cmd.Flag("output-stdout-meta").Changed {
+			printMeta(report)
+		}
+	}()
+
+	cmd, IPs := h.cmd, h.IPs
+	u, err := parseURL(URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if err = checkHostname(u.Hostname()); err != nil {
+		return err
+	}
+	if h.library != nil {
+		if path := h.library.byID(weibo.ImageID(u.Path)); path != "" {
+			fmt.Printf("[SKIPPED] %s | already in the library as %s\n", URL, path)
+			return errSkipped
+		}
+	}
+	ports := []string{u.Port()}
+	if cmd.Flag("ports").Changed {
+		ports, _ = cmd.Flags().GetStringSlice("ports")
+	}
+	IPs = h.order(h, IPs, ports)
+	if len(h.preferred) > 0 { // pinned at the front
+		IPs = append(append(make([]probe.ResolvedIP, 0, len(h.preferred)+len(IPs)), h.preferred...), IPs...)
+	}
+	vhost := cmd.Flag("resolve-host").Value.String()
+	if cmd.Flag("connect-only").Changed {
+		if vhost != "" {
+			v := *u
+			v.Host = net.JoinHostPort(vhost, u.Port())
+			return h.connect(&v, ports, IPs)
+		}
+		return h.connect(u, ports, IPs)
+	}
+	if _, err = hound.NewSelector(cmd.Flag("strategy").Value.String()); err != nil {
+		return err
 	}
-	fmt.Printf("Using %d cached resolves.\n", len(IPs))
 
 	URLs, err := weibo.GenerateURLsOfAllQualities(URL)
 	if err != nil {
 		URLs = []string{URL}
 	}
+	minTiers, _ := cmd.Flags().GetInt("min-upgrade-tiers")
+	requested := weibo.QualityRank(weibo.QualityOf(URL))
+	if minTiers > 0 {
+		if requested < 0 {
+			return fmt.Errorf("%w: unknown quality of the URL", errInsufficientUpgrade)
+		}
+		if minTiers > requested {
+			return fmt.Errorf("%w: no quality is %d tiers higher than %s", errInsufficientUpgrade, minTiers, weibo.QualityOf(URL))
+		}
+	}
+	if vhost != "" {
+		for i := range URLs {
+			if URLs[i], err = replaceHost(URLs[i], vhost); err != nil {
+				return fmt.Errorf("invalid URL: %w", err)
+			}
+		}
+	}
+	allQualities, compare := cmd.Flag("all-qualities").Changed, cmd.Flag("compare-schemes").Changed
+	var recovered []string // qualities saved with --all-qualities
+	var skipped bool       // whether a quality found with --all-qualities was identical to an image in the library
 	var result hound.Result
-	bar := progressbar.Default(int64(len(URLs)) * int64(len(IPs)))
-urls:
-	for _, URL = range URLs {
-		fmt.Printf("Started hunting for %s\n", URL)
-		ctx, cancel := context.WithCancel(cmd.Context())
-		ch := make(chan hound.Result, len(IPs))
-		go hound.Hunt(ctx, ch, URL, u.Port(), IPs, nil)
-		for range IPs {
-			result = <-ch
-			_ = bar.Add(1)
-			if result.Err != nil {
-				fmt.Fprintf(os.Stderr, "[FAILED] %s | %v\n", result.IP.String(), result.Err)
-				continue
+	var found bool
+	total := len(IPs) * len(ports) // number of attempts for each quality
+	if compare {
+		total = len(IPs) * 2
+	}
+	roundPersonas := []persona{{}} // as configured by the flags
+	if cmd.Flag("rotate-persona").Changed {
+		roundPersonas = append(roundPersonas, personas...)
+	}
+	roundHostnames := []string{""}
+	if cmd.Flag("try-all-hosts").Changed {
+		roundHostnames = append(roundHostnames, weibo.SiblingHostnames(u.Hostname())...)
+	}
+	var rounds []huntRound
+	for _, hostname := range roundHostnames {
+		for _, p := range roundPersonas {
+			rounds = append(rounds, huntRound{persona: p, hostname: hostname})
+		}
+	}
+	restore := func() {}
+	defer func() { restore() }()
+	for round, hr := range rounds {
+		if round > 0 {
+			fmt.Printf("Retrying %s%s\n", report.URL, hr.describe())
+			restore()
+			restore = func() {}
+			if hr.persona.name != "" {
+				restore = h.as(hr.persona)
+			}
+		}
+		qualityURLs := URLs
+		if hr.hostname != "" {
+			qualityURLs = make([]string, len(URLs))
+			for i := range URLs {
+				if qualityURLs[i], err = replaceHost(URLs[i], hr.hostname); err != nil {
+					return fmt.Errorf("invalid URL: %w", err)
+				}
+			}
+		}
+		groups := make([][]string, len(qualityURLs)) // of the quality URLs hunted for at once, highest quality first
+		for i := range qualityURLs {
+			groups[i] = qualityURLs[i : i+1]
+		}
+		if cmd.Flag("prefer").Value.String() == "first" {
+			groups = [][]string{qualityURLs}
+		}
+		bar := newProgressBar(int64(len(URLs)) * int64(total))
+		for i, group := range groups {
+			URL = group[0]
+			if allQualities && minTiers > 0 && requested-weibo.QualityRank(weibo.QualityOf(URL)) < minTiers {
+				break // the remaining qualities are even lower
 			}
-			if result.Status != http.StatusOK {
-				if result.Status != http.StatusMovedPermanently {
-					fmt.Fprintf(os.Stderr, "[FAILED] %s | HTTP %d\n", result.IP.String(), result.Status)
+			if h.opts.Budget.Exceeded() {
+				if len(recovered) > 0 {
+					break // keep the qualities saved so far
 				}
+				return fmt.Errorf("gave up before %s: %w", URL, hound.ErrBudgetExceeded)
+			}
+			if i > 0 {
+				h.pause()
+			}
+			if len(group) > 1 {
+				fmt.Printf("Started hunting for all %d qualities of %s\n", len(group), report.URL)
+			} else {
+				fmt.Printf("Started hunting for %s\n", URL)
+			}
+			var r hound.Result
+			var ok bool
+			release := func() {}
+			if compare {
+				r, ok = h.compareSchemes(URL, IPs, bar)
+			} else {
+				r, ok, release = h.huntQuality(group, IPs, ports, bar)
+			}
+			if !ok {
+				continue
+			}
+			if !allQualities {
+				defer release()
+				result, found = r, true
+				break
+			}
+			// save every quality to a separate file
+			quality := weibo.QualityOf(r.URL)
+			n, sum, err := h.save(u, r, "_"+quality)
+			release()
+			if errors.Is(err, errSkipped) {
+				skipped = true
+				continue
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s | %v\n", colorTag(os.Stderr, "[FAILED]"), URL, err)
 				continue
 			}
-			// succeeded
+			recovered = append(recovered, quality)
+			report.IP, report.Port, report.Status = r.IP, r.Port, r.Status
+			report.Size += n
+			if report.SHA256 == "" { // of the highest quality
+				report.SHA256 = sum
+			}
+		}
+		if found || len(recovered) > 0 || skipped {
+			if report.Persona = hr.persona.name; round > 0 {
+				fmt.Printf("Found%s.\n", hr.describe())
+			}
+			break
+		}
+	}
+	if allQualities && len(recovered) > 0 {
+		report.Quality = strings.Join(recovered, " ")
+		fmt.Printf("Recovered %d of %d qualities: %s\n", len(recovered), len(URLs), strings.Join(recovered, ", "))
+		return nil
+	}
+	if skipped {
+		return errSkipped
+	}
+	if !found {
+		fmt.Printf("%s Unfortunately, all %d resolves failed.\n", colorTag(os.Stdout, "[FAILED]"), len(IPs))
+		h.explain()
+		h.diagnose(URLs, IPs, ports)
+		return errAllFailed
+	}
+	report.Quality, report.IP, report.Port, report.Status = weibo.QualityOf(result.URL), result.IP, result.Port, result.Status
+	if minTiers > 0 && requested-weibo.QualityRank(report.Quality) < minTiers {
+		return fmt.Errorf("%w: found %s, less than %d tiers higher than %s", errInsufficientUpgrade, report.Quality, minTiers, weibo.QualityOf(report.URL))
+	}
+	report.Size, report.SHA256, err = h.save(u, result, "")
+	return err
+}
+
+// huntQuality hunts for the image of the given quality URLs (all at once, if more than one) from the given IPs
+// on the given ports, and returns the selected result if found, along with the function releasing its request,
+// which must be called after its body is consumed.
+func (h *hunter) huntQuality(URLs []string, IPs []probe.ResolvedIP, ports []string, bar *progressbar.ProgressBar) (hound.Result, bool, context.CancelFunc) {
+	cmd, opts, URL := h.cmd, h.opts, URLs[0]
+	if len(URLs) > 1 {
+		URL = fmt.Sprintf("%d qualities", len(URLs))
+	}
+	selector, _ := hound.NewSelector(cmd.Flag("strategy").Value.String())
+	total := len(IPs) * len(ports) * len(URLs) // number of attempts
+	ctx, cancel := context.WithCancel(cmd.Context())
+	candidates, candidatePorts := IPs, ports
+	if peek, _ := cmd.Flags().GetInt("peek"); peek > 0 {
+		peeked, dims, err := hound.Peek(ctx, URLs[0], ports, IPs, h.headers, opts, peek*1024)
+		if err != nil {
+			cancel()
+			_ = bar.Add(total)
+			fmt.Printf("%s Peeking failed for %s: %v\n", colorTag(os.Stdout, "[FAILED]"), URL, err)
+			return hound.Result{}, false, nil
+		}
+		fmt.Printf("Peeked %dx%d from %s\n", dims.X, dims.Y, net.JoinHostPort(peeked.IP.String(), peeked.Port))
+		if err = h.checkDimensions(dims); err != nil {
+			cancel()
+			_ = bar.Add(total)
+			fmt.Printf("%s Peeked image of %s is unexpected: %v\n", colorTag(os.Stdout, "[FAILED]"), URL, err)
+			return hound.Result{}, false, nil
+		}
+		if peeked.Status == http.StatusOK { // Range ignored, already fully downloaded
 			cancel()
-			break urls
+			_ = bar.Add(total)
+			err = h.check(peeked)
+			h.recordAttempt(peeked, err)
+			if err != nil {
+				fmt.Printf("%s Peeked image of %s is not a hit: %v\n", colorTag(os.Stdout, "[FAILED]"), URL, err)
+				return hound.Result{}, false, nil
+			}
+			return peeked, true, func() {}
+		}
+		_ = bar.Add(total - 1)
+		candidates, candidatePorts = []probe.ResolvedIP{peeked.IP}, []string{peeked.Port}
+	}
+	n := len(candidates) * len(candidatePorts) * len(URLs)
+	ch := make(chan hound.Result, n)
+	for _, u := range URLs {
+		go hound.Hunt(ctx, ch, u, candidatePorts, candidates, h.headers, opts)
+	}
+	received, connectFailed, handshakeFailed := 0, 0, 0
+	for i := 0; i < n; i++ {
+		result := <-ch
+		received++
+		_ = bar.Add(1)
+		h.captureCerts(result)
+		if result.HTTP1Fallback {
+			fmt.Fprintf(os.Stderr, "[FALLBACK] %s | retried over HTTP/1.1 after an HTTP/2 error\n", net.JoinHostPort(result.IP.String(), result.Port))
+		}
+		err := h.check(result)
+		h.recordAttempt(result, err)
+		if err != nil {
+			if errors.Is(result.Err, hound.ErrBudgetExceeded) {
+				fmt.Fprintf(os.Stderr, "%s %s | %v, giving up the remaining requests\n", colorTag(os.Stderr, "[FAILED]"), net.JoinHostPort(result.IP.String(), result.Port), err)
+				break
+			}
+			if errors.Is(result.Err, hound.ErrConnect) {
+				connectFailed++
+			} else if errors.Is(result.Err, hound.ErrTLSHandshake) {
+				handshakeFailed++
+			}
+			if result.Err != nil || result.Status != http.StatusMovedPermanently {
+				fmt.Fprintf(os.Stderr, "%s %s | %v%s\n", colorTag(os.Stderr, "[FAILED]"), net.JoinHostPort(result.IP.String(), result.Port), err, redirectsOf(result))
+			}
+			continue
+		}
+		// succeeded
+		if selector.Offer(result) {
+			break
+		}
+	}
+	if result, found := selector.Selected(); found {
+		if opts.Stream { // keep the winning request alive until its body is written
+			go drainResults(ch, n-received)
+			return result, true, cancel
 		}
 		cancel()
-		fmt.Printf("[FAILED] All failed for %s\n", URL)
+		return result, true, func() {}
 	}
-	if result.Err != nil || result.Status != http.StatusOK {
-		fmt.Printf("[FAILED] Unfortunately, all %d resolves failed.\n", len(IPs))
-		return
+	cancel()
+	if connectFailed > 0 || handshakeFailed > 0 {
+		fmt.Printf("%s All failed for %s (%d at connecting, %d at the TLS handshake)\n", colorTag(os.Stdout, "[FAILED]"), URL, connectFailed, handshakeFailed)
+	} else {
+		fmt.Printf("%s All failed for %s\n", colorTag(os.Stdout, "[FAILED]"), URL)
 	}
+	return hound.Result{}, false, nil
+}
+
+// check returns why the given result is not a hit, nil if it is,
+// and marks its IP as serving censored content if it did.
+func (h *hunter) check(result hound.Result) error {
+	if result.Status == http.StatusForbidden && !h.opts.Accepts(result.Status) { // not e.g. 404 of a missing quality, nor 30x of a redirect
+		h.censored[result.IP.String()] = struct{}{}
+	}
+	if h.opts.Hit(result) {
+		return nil
+	}
+	// not a hit, explain why
+	if result.Err != nil {
+		return result.Err
+	}
+	if !h.opts.Accepts(result.Status) {
+		return fmt.Errorf("HTTP %d", result.Status)
+	}
+	if !h.opts.Stream && weibo.IsPlaceholder(result.Body, h.opts.PlaceholderHashes) {
+		h.censored[result.IP.String()] = struct{}{}
+		return errors.New("known placeholder")
+	}
+	if err := h.checkImage(result.Body); err != nil {
+		return err
+	}
+	return errors.New("not a hit")
+}
 
-	fmt.Printf("[SUCCESS] %s | %s | %d\n", URL, result.IP.String(), len(result.Body))
+// save saves the found image (hunted for u) to the output path,
+// with the given suffix appended to the auto filename, and returns the number of bytes written and their SHA-256 hash.
+// It returns errSkipped without saving if the image is identical to one in the library, with --skip-existing.
+func (h *hunter) save(u *url.URL, result hound.Result, suffix string) (int64, string, error) {
+	cmd, opts, URL := h.cmd, h.opts, result.URL
+	h.winners = append(h.winners, result.IP)
+	if opts.Stream {
+		fmt.Printf("%s %s | %s | streaming%s\n", colorTag(os.Stdout, "[SUCCESS]"), URL, net.JoinHostPort(result.IP.String(), result.Port), redirectsOf(result))
+	} else {
+		fmt.Printf("%s %s | %s | %d%s\n", colorTag(os.Stdout, "[SUCCESS]"), URL, net.JoinHostPort(result.IP.String(), result.Port), len(result.Body), redirectsOf(result))
+	}
+	if cmd.Flag("detect-watermark").Changed {
+		if ok, reason := weibo.DetectWatermark(result.Headers, result.Body); ok {
+			fmt.Printf("[WARNING] The image is likely watermarked (%s), you may want to keep looking.\n", reason)
+		}
+	}
 	// write to file
+	var body io.Reader = bytes.NewReader(result.Body)
+	sniff := result.Body
+	if result.BodyReader != nil {
+		defer result.BodyReader.Close()
+		br := bufio.NewReader(result.BodyReader)
+		sniff, _ = br.Peek(512)
+		body = br
+	}
+	filename := h.filename
 	if filename == "." || filename == "/" { // build filename when not specified
 		filename = u.Path[strings.LastIndex(u.Path, "/")+1:]
-		if strings.LastIndex(filename, ".") == -1 { // no extension or empty
+		fileExt := filepath.Ext(filename)
+		if fileExt == "" { // no extension or empty
 			mimeType := result.Headers.Get("content-type")
 			if mimeType == "" {
-				mimeType = http.DetectContentType(result.Body)
-			}
-			var fileExt string
-			switch mimeType {
-			case "image/jpeg": // avoid using ".jfif" from mime.ExtensionsByType
-				fileExt = ".jpg"
-			case "application/octet-stream":
-				fileExt = ".bin"
-			default:
-				if exts, err := mime.ExtensionsByType(mimeType); err != nil || len(exts) == 0 {
-					fileExt = ".bin"
-				} else {
-					fileExt = exts[0]
-				}
+				mimeType = http.DetectContentType(sniff)
 			}
+			fileExt = extensionOf(mimeType)
 			if filename == "" { // use current unix timestamp
 				filename = strconv.FormatInt(time.Now().Unix(), 10)
 			}
 			filename += fileExt
 		}
+		filename = strings.TrimSuffix(filename, fileExt) + suffix + fileExt
 	}
-	path := filepath.Join(dir, filename)
-	if err := os.WriteFile(path, result.Body, 0644); err != nil {
-		panic(err)
+	path := filepath.Join(h.dir, filename)
+	if h.outFile != nil {
+		path = h.outFile.Name()
+	}
+	var sidecar *meta.Metadata
+	if cmd.Flag("embed-metadata").Changed {
+		m := meta.Metadata{SourceURL: URL, IP: result.IP.String(), Date: time.Now(), Tool: "weibo-image-hound " + version}
+		if data, err := meta.Embed(result.Body, m); err == nil {
+			body = bytes.NewReader(data)
+		} else {
+			if !errors.Is(err, meta.ErrUnsupported) {
+				fmt.Fprintf(os.Stderr, "Failed to embed metadata: %v\n", err)
+			}
+			sidecar = &m
+		}
+	}
+	if h.library != nil && result.Body != nil {
+		sum := sha256.Sum256(result.Body)
+		if existing := h.library.byHash(hex.EncodeToString(sum[:])); existing != "" {
+			fmt.Printf("[SKIPPED] %s | identical to %s in the library\n", URL, existing)
+			return 0, hex.EncodeToString(sum[:]), errSkipped
+		}
+	}
+	hash := sha256.New()
+	body = io.TeeReader(body, hash)
+	var n int64
+	var err error
+	if h.outFile != nil {
+		if n, err = io.Copy(h.outFile, body); err != nil {
+			return n, "", fmt.Errorf("failed to write output: %w", err)
+		}
+	} else if n, err = writeOutput(path, body, h.fileMode); err != nil {
+		return 0, "", err
+	}
+	if sidecar != nil && h.outFile != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write metadata: no sidecar file when writing to a file descriptor\n")
+	} else if sidecar != nil {
+		if err = meta.WriteSidecar(path, *sidecar, h.fileMode); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write metadata: %v\n", err)
+		} else {
+			fmt.Printf("Saved metadata to %s.json\n", path)
+		}
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	fmt.Printf("Saved %s to %s (%d bytes)\n", URL, path, n)
+	h.paths = append(h.paths, path)
+
+	if cmd.Flag("preview").Changed {
+		data := result.Body
+		if data == nil && h.outFile != nil {
+			fmt.Fprintf(os.Stderr, "Failed to render preview: the streamed image was written to a file descriptor\n")
+			return n, sum, nil
+		}
+		if data == nil { // streamed, read it back
+			if data, err = os.ReadFile(path); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read saved image for preview: %v\n", err)
+				return n, sum, nil
+			}
+		}
+		if err = printPreview(data); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to render preview: %v\n", err)
+		}
+	}
+	return n, sum, nil
+}
+
+// isHit is the predicate of the hits of the hunt (see hound.Options.IsHit), agreeing with check.
+func (h *hunter) isHit(result hound.Result) bool {
+	return h.opts.DefaultIsHit(result) && h.checkImage(result.Body) == nil
+}
+
+// checkImage returns an error if the given image doesn't have the expected dimensions (see checkDimensions).
+func (h *hunter) checkImage(body []byte) error {
+	if !h.cmd.Flag("expect-width").Changed && !h.cmd.Flag("expect-height").Changed {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	return h.checkDimensions(image.Point{X: cfg.Width, Y: cfg.Height})
+}
+
+// checkDimensions returns an error if the given image dimensions don't match the ones given by --expect-width
+// and --expect-height, within --expect-tolerance.
+func (h *hunter) checkDimensions(dims image.Point) error {
+	tolerance, _ := h.cmd.Flags().GetInt("expect-tolerance")
+	if width, _ := h.cmd.Flags().GetInt("expect-width"); h.cmd.Flag("expect-width").Changed && abs(dims.X-width) > tolerance {
+		return fmt.Errorf("image is %dx%d, expected width %d", dims.X, dims.Y, width)
+	}
+	if height, _ := h.cmd.Flags().GetInt("expect-height"); h.cmd.Flag("expect-height").Changed && abs(dims.Y-height) > tolerance {
+		return fmt.Errorf("image is %dx%d, expected height %d", dims.X, dims.Y, height)
+	}
+	return nil
+}
+
+// abs returns the absolute value of x.
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// pause sleeps for the jittered delay between the rounds of qualities, if any.
+func (h *hunter) pause() {
+	delay, _ := h.cmd.Flags().GetDuration("round-delay")
+	if delay <= 0 {
+		return
+	}
+	delay = delay/2 + time.Duration(h.rand.Int63n(int64(delay)+1))
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-h.cmd.Context().Done():
+	}
+}
+
+// diagnose checks whether the image exists on any of the given IPs (the ones hunted from) with HEAD requests
+// after all GET requests failed, to distinguish images blocked for GET (censored) from the truly unavailable ones.
+func (h *hunter) diagnose(URLs []string, IPs []probe.ResolvedIP, ports []string) {
+	opts := h.opts
+	opts.Method = http.MethodHead
+	opts.Stream = false
+	for _, URL := range URLs {
+		ctx, cancel := context.WithCancel(h.cmd.Context())
+		n := len(IPs) * len(ports)
+		ch := make(chan hound.Result, n)
+		go hound.Hunt(ctx, ch, URL, ports, IPs, h.headers, opts)
+		for i := 0; i < n; i++ {
+			r := <-ch
+			if r.Err == nil && opts.Accepts(r.Status) {
+				cancel()
+				size := r.Headers.Get("content-length")
+				if size == "" {
+					size = "unknown"
+				}
+				fmt.Printf("[DIAGNOSIS] The image exists (%s | %s | %s bytes), but is blocked for downloading.\n", URL, net.JoinHostPort(r.IP.String(), r.Port), size)
+				return
+			}
+		}
+		cancel()
+	}
+	fmt.Println("[DIAGNOSIS] The image is not available on any resolve, even with HEAD requests.")
+}
+
+// drainResults receives the given number of remaining results from ch in streaming mode, closing their bodies.
+func drainResults(ch <-chan hound.Result, n int) {
+	for i := 0; i < n; i++ {
+		if r := <-ch; r.BodyReader != nil {
+			r.BodyReader.Close()
+		}
+	}
+}
+
+// readHTMLURLs reads the text (e.g. an HTML snippet of a Weibo page) of the file at path, or stdin if path is "-",
+// and returns the image URLs in it.
+func readHTMLURLs(path string) ([]string, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HTML: %w", err)
 	}
-	fmt.Printf("Saved %s to %s\n", URL, path)
+	return weibo.ExtractImageURLs(string(b)), nil
 }
 
 // parseURL parses a URL string and returns an url.URL struct, with all the required stuff fixed up.
@@ -141,6 +982,11 @@ func parseURL(URL string) (*url.URL, error) {
 	if err != nil {
 		return nil, err
 	}
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(weibo.NormalizeHostname(u.Hostname()), port)
+	} else {
+		u.Host = weibo.NormalizeHostname(u.Hostname())
+	}
 	if u.Port() == "" {
 		if u.Scheme == "https" {
 			u.Host += ":443"
@@ -158,9 +1004,175 @@ func parseURL(URL string) (*url.URL, error) {
 	return u, nil
 }
 
+// replaceHost returns the given URL with its hostname replaced by the given one, keeping the port if any.
+func replaceHost(URL string, hostname string) (string, error) {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return "", err
+	}
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(hostname, port)
+	} else {
+		u.Host = hostname
+	}
+	return u.String(), nil
+}
+
+// checkHostname returns an error if the given hostname is not a Weibo image CDN hostname,
+// as the cached resolves can't serve it; and warns if it's not one of the known hostnames.
+func checkHostname(hostname string) error {
+	cdn, known := weibo.IsCDNHostname(hostname)
+	if !cdn {
+		return fmt.Errorf("\"%s\" is not a Weibo image CDN hostname", hostname)
+	}
+	if !known {
+		fmt.Fprintf(os.Stderr, "Warning: \"%s\" is not one of the known hostnames (%s), hunting may not work.\n",
+			hostname, strings.Join(weibo.Hostnames(), ", "))
+	}
+	return nil
+}
+
+// exceptIPs returns the given IPs except the excluded ones.
+func exceptIPs(IPs []probe.ResolvedIP, excluded []probe.ResolvedIP) []probe.ResolvedIP {
+	r := make([]probe.ResolvedIP, 0, len(IPs))
+	for _, IP := range IPs {
+		if !slices.ContainsFunc(excluded, func(e probe.ResolvedIP) bool { return e.IP.Equal(IP.IP) }) {
+			r = append(r, IP)
+		}
+	}
+	return r
+}
+
+// filterPlaces returns the given IPs resolved from any of the given places,
+// where placesOf returns the places (e.g. countries or regions) an IP was resolved from.
+func filterPlaces(IPs []probe.ResolvedIP, places []string, placesOf func(probe.ResolvedIP) []string) []probe.ResolvedIP {
+	r := make([]probe.ResolvedIP, 0, len(IPs))
+	for _, IP := range IPs {
+		for _, p := range places {
+			if slices.ContainsFunc(placesOf(IP), func(s string) bool { return strings.EqualFold(s, p) }) {
+				r = append(r, IP)
+				break
+			}
+		}
+	}
+	return r
+}
+
+// defaultExtensions are the file extensions of the MIME types where the system MIME database may pick surprising ones.
+var defaultExtensions = map[string]string{
+	"image/jpeg":               ".jpg", // avoid using ".jfif" from mime.ExtensionsByType
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+	"image/avif":               ".avif",
+	"image/heic":               ".heic",
+	"application/octet-stream": ".bin",
+}
+
+// extensionOf returns the file extension of the given MIME type, from "hunt.extensions" in config,
+// the defaults, then the system MIME database, or ".bin" if unknown.
+func extensionOf(mimeType string) string {
+	if t, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = t
+	}
+	if ext, ok := config.Hunt.Extensions[mimeType]; ok {
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		return ext
+	}
+	if ext, ok := defaultExtensions[mimeType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// openOutputFD returns the file descriptor to write the image of a single URL to, given by the --output-fd flag of cmd
+// or referred to by its --output path (see outputFDOf), nil if neither,
+// moving informational output to stderr when it's stdout.
+func openOutputFD(cmd *cobra.Command, numURLs int) (*os.File, error) {
+	fd, _ := cmd.Flags().GetInt("output-fd")
+	if cmd.Flag("output-fd").Changed {
+		if cmd.Flag("output").Changed {
+			return nil, fmt.Errorf("--output-fd doesn't work with --output")
+		}
+	} else if fd = outputFDOf(cmd.Flag("output").Value.String()); fd < 0 {
+		return nil, nil
+	}
+	if numURLs > 1 || cmd.Flag("all-qualities").Changed {
+		return nil, fmt.Errorf("writing to a file descriptor only works with a single image")
+	}
+	switch fd {
+	case 0, 2:
+		return nil, fmt.Errorf("--output-fd can't be stdin or stderr")
+	case 1:
+		out := os.Stdout
+		os.Stdout = os.Stderr // keep the image the only output
+		return out, nil
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor: %d", fd)
+	}
+	if _, err := f.Stat(); err != nil {
+		return nil, fmt.Errorf("invalid file descriptor %d: %w", fd, err)
+	}
+	return f, nil
+}
+
+// outputFDOf returns the file descriptor of this process the given output path refers to,
+// e.g. 3 for /dev/fd/3, or 1 for /dev/stdout or any other path of the file stdout is, -1 if none.
+func outputFDOf(path string) int {
+	if path == "" {
+		return -1
+	}
+	for _, prefix := range []string{"/dev/fd/", "/proc/self/fd/"} {
+		if n, ok := strings.CutPrefix(path, prefix); ok {
+			if fd, err := strconv.Atoi(n); err == nil {
+				return fd
+			}
+		}
+	}
+	if path == "/dev/stdout" {
+		return 1
+	}
+	f, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	if stdout, err := os.Stdout.Stat(); err == nil && os.SameFile(f, stdout) { // e.g. the terminal, or the file redirected to
+		return 1
+	}
+	return -1
+}
+
+// authHeaders returns the Authorization header given by the --user or --bearer flag of cmd, nil if neither.
+func authHeaders(cmd *cobra.Command) (http.Header, error) {
+	user, bearer := cmd.Flag("user").Value.String(), cmd.Flag("bearer").Value.String()
+	switch {
+	case user != "" && bearer != "":
+		return nil, fmt.Errorf("--user doesn't work with --bearer")
+	case user != "":
+		name, password, ok := strings.Cut(user, ":")
+		if !ok {
+			return nil, fmt.Errorf("--user must be in the form of user:password")
+		}
+		req := http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(name, password)
+		return req.Header, nil
+	case bearer != "":
+		return http.Header{"Authorization": {"Bearer " + bearer}}, nil
+	}
+	return nil, nil
+}
+
 // parseOutputPath parses a path string and returns the absolute path to the directory, and filename.
 // If the given path points to a directory, the filename will be "/".
-func parseOutputPath(path string) (dir string, filename string, err error) {
+// The directory is created with the given mode if not existing.
+func parseOutputPath(path string, dirMode os.FileMode) (dir string, filename string, err error) {
 	if !filepath.IsAbs(path) {
 		cwd, err := os.Getwd()
 		if err != nil {
@@ -169,7 +1181,11 @@ func parseOutputPath(path string) (dir string, filename string, err error) {
 		path = filepath.Join(cwd, path)
 	}
 	dir, filename = filepath.Split(path)
-	if f, err := os.Stat(dir); err != nil {
+	if f, err := os.Stat(dir); os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, dirMode); err != nil {
+			return "", "", fmt.Errorf("failed to create directory: %w", err)
+		}
+	} else if err != nil {
 		return "", "", err
 	} else if !f.IsDir() {
 		return "", "", fmt.Errorf("directory not exists")
@@ -180,3 +1196,68 @@ func parseOutputPath(path string) (dir string, filename string, err error) {
 	}
 	return dir, filename, nil
 }
+
+// parseFileMode parses an octal file mode from the given flag if set, or the given config value if not empty,
+// otherwise returns the default mode with the process umask applied.
+func parseFileMode(cfg string, flag *pflag.Flag, def os.FileMode) (os.FileMode, error) {
+	s := cfg
+	if flag.Changed {
+		s = flag.Value.String()
+	}
+	if s == "" {
+		return def &^ umask(), nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("\"%s\" is not a valid octal permission", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// writeOutput writes the data read from r to the file at path with the given mode, and returns the number of bytes written.
+// Regular files are written to a temporary file first and then renamed into place,
+// while special files (named pipes, character devices) are opened and written to directly.
+func writeOutput(path string, r io.Reader, mode os.FileMode) (int64, error) {
+	if f, err := os.Stat(path); err == nil && f.Mode()&(os.ModeNamedPipe|os.ModeCharDevice) != 0 {
+		out, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open output file: %w", err)
+		}
+		defer out.Close()
+		n, err := io.Copy(out, r)
+		if err != nil {
+			return n, fmt.Errorf("failed to write output file: %w", err)
+		}
+		return n, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return n, fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return n, fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return n, fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return n, fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	return n, nil
+}
+
+// redirectsOf returns the description of the redirect chain followed for the given result, or "" if none.
+func redirectsOf(result hound.Result) string {
+	if len(result.Redirects) == 0 {
+		return ""
+	}
+	return " (redirected via " + strings.Join(result.Redirects, " -> ") + ")"
+}
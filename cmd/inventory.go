@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"weibo-image-hound/internal/hound"
+	"weibo-image-hound/internal/weibo"
+)
+
+// inventoryCmd represents the inventory command
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory [URL]",
+	Short: "Report which qualities of a Weibo image are available, given its URL",
+	Long: `Report which qualities of a Weibo image are available, given its URL. 
+Each quality is requested with HEAD requests from all cached resolves, nothing is downloaded or saved. 
+Example: weibo-image-hound inventory https://wx4.sinaimg.cn/mw2000/c49cf6fdgy1hjwxqm5ctrj20k04zytjs.jpg`,
+	Run: inventory,
+}
+
+func init() {
+	rootCmd.AddCommand(inventoryCmd)
+}
+
+func inventory(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		_ = cmd.Help()
+		return
+	}
+
+	URL := args[0]
+	u, err := parseURL(URL)
+	if err != nil {
+		panic(fmt.Errorf("invalid URL: %w", err))
+	}
+	if err = checkHostname(u.Hostname()); err != nil {
+		panic(err)
+	}
+	URLs, err := weibo.GenerateURLsOfAllQualities(URL)
+	if err != nil {
+		panic(err)
+	}
+
+	IPs := filterAllowed(filterBlacklisted(config.Cache.Resolves))
+	if len(IPs) == 0 {
+		fmt.Println("No cached resolves found, please run `weibo-image-hound cache` first")
+		return
+	}
+	fmt.Printf("Using %d cached resolves.\n", len(IPs))
+
+	opts := hound.Options{Method: http.MethodHead, StatusCodes: config.Hunt.StatusCodes}
+	fmt.Printf("%-10s | %-9s | %-10s | %s\n", "QUALITY", "EDGES", "SIZE", "EXAMPLE EDGE")
+	for i, q := range weibo.Qualities() {
+		ctx, cancel := context.WithCancel(cmd.Context())
+		ch := make(chan hound.Result, len(IPs))
+		go hound.Hunt(ctx, ch, URLs[i], []string{u.Port()}, IPs, nil, opts)
+		var available int
+		var size, example string
+		for range IPs {
+			r := <-ch
+			if r.Err != nil || !opts.Accepts(r.Status) {
+				continue
+			}
+			available++
+			if example == "" {
+				example = r.IP.String()
+			}
+			if l := r.Headers.Get("content-length"); l != "" && size == "" {
+				if n, err := strconv.ParseInt(l, 10, 64); err == nil {
+					size = strconv.FormatInt(n, 10)
+				}
+			}
+		}
+		cancel()
+		if size == "" {
+			size = "-"
+		}
+		if example == "" {
+			example = "-"
+		}
+		fmt.Printf("%-10s | %4d/%-4d | %-10s | %s\n", q, available, len(IPs), size, example)
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"weibo-image-hound/internal/weibo"
+)
+
+// library is a local directory of saved images, for `hunt --skip-existing` to skip the images already in it.
+type library struct {
+	paths  []string
+	IDs    map[string]string // Weibo image ID -> path
+	hashes map[string]string // SHA-256 (in hex) -> path, computed on first use
+}
+
+// loadLibrary indexes the images in the given directory and its subdirectories by the Weibo image IDs in their names.
+func loadLibrary(dir string) (*library, error) {
+	l := &library{IDs: make(map[string]string)}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		l.paths = append(l.paths, path)
+		if ID := weibo.ImageID(d.Name()); ID != "" {
+			l.IDs[ID] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read library: %w", err)
+	}
+	return l, nil
+}
+
+// byID returns the path of the image of the given Weibo image ID in the library, empty if none.
+func (l *library) byID(ID string) string {
+	if ID == "" {
+		return ""
+	}
+	return l.IDs[ID]
+}
+
+// byHash returns the path of the image of the given SHA-256 hash (in hex) in the library, empty if none.
+// All images are hashed on the first call.
+func (l *library) byHash(sum string) string {
+	if l.hashes == nil {
+		l.hashes = make(map[string]string, len(l.paths))
+		for _, path := range l.paths {
+			if s, err := hashFile(path); err == nil {
+				l.hashes[s] = path
+			} else {
+				fmt.Fprintf(os.Stderr, "Failed to hash %s: %v\n", path, err)
+			}
+		}
+	}
+	return l.hashes[sum]
+}
+
+// hashFile returns the SHA-256 hash (in hex) of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
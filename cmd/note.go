@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// noteCmd represents the note command
+var noteCmd = &cobra.Command{
+	Use:   "note IP [NOTE]... [flags]",
+	Short: "Show, set or delete the note of an IP",
+	Long: `Show, set or delete the note of an IP, e.g. to keep track of hand-curated edges. 
+Notes are shown in the output of "cache list" and "stats". 
+Example: weibo-image-hound note 1.2.3.4 reliable HK edge`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  note,
+}
+
+// cacheListCmd represents the cache list command
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached resolves with where they were resolved from and their notes",
+	Long: `List the cached resolves with where they were resolved from, their censored counts, and their notes. 
+Example: weibo-image-hound cache list`,
+	Run: cacheList,
+}
+
+func init() {
+	rootCmd.AddCommand(noteCmd)
+	noteCmd.Flags().BoolP("delete", "d", false, "delete the note of the IP")
+	cacheCmd.AddCommand(cacheListCmd)
+}
+
+func note(cmd *cobra.Command, args []string) {
+	IP := net.ParseIP(args[0])
+	if IP == nil {
+		panic(fmt.Errorf("invalid IP: %s", args[0]))
+	}
+	key := IP.String()
+	switch {
+	case cmd.Flag("delete").Changed:
+		delete(config.Notes, key)
+		saveConfig()
+		fmt.Printf("Note of %s deleted.\n", key)
+	case len(args) > 1:
+		if config.Notes == nil {
+			config.Notes = make(map[string]string)
+		}
+		config.Notes[key] = strings.Join(args[1:], " ")
+		saveConfig()
+		fmt.Printf("Note of %s set.\n", key)
+	default:
+		if n, ok := config.Notes[key]; ok {
+			fmt.Println(n)
+		} else {
+			fmt.Printf("No note of %s.\n", key)
+		}
+	}
+}
+
+func cacheList(cmd *cobra.Command, args []string) {
+	IPs := append([]probe.ResolvedIP(nil), config.Cache.Resolves...)
+	sort.Slice(IPs, func(i, j int) bool { return IPs[i].String() < IPs[j].String() })
+	for _, IP := range IPs {
+		places := append(append([]string(nil), IP.Countries...), IP.Regions...)
+		if IP.Local {
+			places = append(places, "local")
+		}
+		for _, n := range IP.ASNs {
+			places = append(places, fmt.Sprintf("AS%d", n))
+		}
+		from := strings.Join(places, ", ")
+		fmt.Printf("%s | %s | %d | %s\n", IP.String(), from, config.Cache.Censored[IP.String()], config.Notes[IP.String()])
+	}
+	fmt.Printf("%d cached resolves.\n", len(IPs))
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"weibo-image-hound/internal/hound"
+	"weibo-image-hound/internal/probe"
+)
+
+// orderers are the strategies of ordering the cached resolves before each hunt on the given ports,
+// by the name selectable with --order.
+var orderers = map[string]func(h *hunter, IPs []probe.ResolvedIP, ports []string) []probe.ResolvedIP{
+	"config":  func(_ *hunter, IPs []probe.ResolvedIP, _ []string) []probe.ResolvedIP { return IPs },
+	"random":  func(h *hunter, IPs []probe.ResolvedIP, _ []string) []probe.ResolvedIP { return h.orderRandom(IPs) },
+	"region":  func(h *hunter, IPs []probe.ResolvedIP, _ []string) []probe.ResolvedIP { return h.orderRegion(IPs) },
+	"latency": (*hunter).orderLatency,
+	"success": func(h *hunter, IPs []probe.ResolvedIP, _ []string) []probe.ResolvedIP { return h.orderSuccess(IPs) },
+}
+
+// orderNames returns the names of all orderers, sorted.
+func orderNames() []string {
+	names := make([]string, 0, len(orderers))
+	for name := range orderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// orderRandom returns the given IPs in random order, seeded by --seed.
+func (h *hunter) orderRandom(IPs []probe.ResolvedIP) []probe.ResolvedIP {
+	IPs = append([]probe.ResolvedIP(nil), IPs...)
+	h.rand.Shuffle(len(IPs), func(i, j int) { IPs[i], IPs[j] = IPs[j], IPs[i] })
+	return IPs
+}
+
+// orderRegion returns the given IPs reordered to spread across the regions they were resolved from first, then networks.
+func (h *hunter) orderRegion(IPs []probe.ResolvedIP) []probe.ResolvedIP {
+	results := make([]hound.Result, 0, len(IPs))
+	for _, IP := range IPs {
+		results = append(results, hound.Result{IP: IP})
+	}
+	ordered := make([]probe.ResolvedIP, 0, len(IPs))
+	for _, r := range hound.Diverse(results, regionOfResult, networkOfResult) {
+		ordered = append(ordered, r.IP)
+	}
+	return ordered
+}
+
+// orderLatency returns the given IPs ordered by their TCP connect time on the given ports (the fastest of them),
+// the unreachable ones last. The connect times are measured once for all hunts on the same ports.
+func (h *hunter) orderLatency(IPs []probe.ResolvedIP, ports []string) []probe.ResolvedIP {
+	if on := strings.Join(ports, ","); h.latencies == nil || h.latencyOn != on {
+		h.latencies, h.latencyOn = make(map[string]time.Duration, len(IPs)), on
+		ctx, cancel := context.WithCancel(h.cmd.Context())
+		defer cancel()
+		n := len(IPs) * len(ports)
+		ch := make(chan hound.ConnectResult, n)
+		go hound.Connect(ctx, ch, "", ports, IPs, h.opts)
+		for i := 0; i < n; i++ {
+			r := <-ch
+			if d, ok := h.latencies[r.IP.String()]; r.Err == nil && (!ok || r.Connect < d) {
+				h.latencies[r.IP.String()] = r.Connect
+			}
+		}
+		fmt.Printf("Measured the latency of %d reachable resolves of %d.\n", len(h.latencies), len(IPs))
+	}
+	latency := func(IP probe.ResolvedIP) time.Duration {
+		if d, ok := h.latencies[IP.String()]; ok {
+			return d
+		}
+		return time.Duration(1<<63 - 1)
+	}
+	IPs = append([]probe.ResolvedIP(nil), IPs...)
+	slices.SortStableFunc(IPs, func(a, b probe.ResolvedIP) int { return cmp.Compare(latency(a), latency(b)) })
+	return IPs
+}
+
+// orderSuccess returns the given IPs ordered by the number of successful hunts in the history database
+// (see historyDBPath), or else the history file, most first,
+// then by the number of hunts they served censored content in, fewest first.
+func (h *hunter) orderSuccess(IPs []probe.ResolvedIP) []probe.ResolvedIP {
+	if h.successes == nil {
+		h.successes = make(map[string]int)
+		if path := historyDBPath(); path != "" {
+			successes, err := querySuccesses(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+			}
+			for host, n := range successes {
+				h.successes[host] = n
+			}
+		} else if path := config.Hunt.HistoryFile; path == "" {
+			fmt.Fprintln(os.Stderr, "Warning: no history configured, ordering by censored counts only.")
+		} else if entries, err := readHistory(path, time.Time{}); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+		} else {
+			for _, e := range entries {
+				if host, _, err := net.SplitHostPort(e.IP); err == nil && e.Success {
+					h.successes[host]++
+				}
+			}
+		}
+	}
+	IPs = append([]probe.ResolvedIP(nil), IPs...)
+	slices.SortStableFunc(IPs, func(a, b probe.ResolvedIP) int {
+		if d := h.successes[b.String()] - h.successes[a.String()]; d != 0 {
+			return d
+		}
+		return config.Cache.Censored[a.String()] - config.Cache.Censored[b.String()]
+	})
+	return IPs
+}
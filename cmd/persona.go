@@ -0,0 +1,76 @@
+package cmd
+
+import "net/http"
+
+// persona is a browser to request as, of a TLS ClientHello profile (see hound.TLSProfiles) and the identifying headers.
+type persona struct {
+	name       string
+	tlsProfile string
+	headers    http.Header // empty values remove the default headers
+}
+
+// personas are the personas rotated through by `hunt --rotate-persona` after the one of the flags failed.
+var personas = []persona{
+	{
+		name:       "chrome-windows",
+		tlsProfile: "chrome",
+		headers:    http.Header{}, // the default headers
+	},
+	{
+		name:       "chrome-android",
+		tlsProfile: "chrome",
+		headers: http.Header{
+			"Sec-Ch-Ua-Mobile":   {"?1"},
+			"Sec-Ch-Ua-Platform": {"Android"},
+			"User-Agent":         {"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Mobile Safari/537.36"},
+		},
+	},
+	{
+		name:       "safari-macos",
+		tlsProfile: "go",
+		headers: http.Header{
+			"Accept":             {"image/webp,image/avif,image/jxl,image/heic,image/heic-sequence,video/*;q=0.8,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"},
+			"Sec-Ch-Ua":          {""},
+			"Sec-Ch-Ua-Mobile":   {""},
+			"Sec-Ch-Ua-Platform": {""},
+			"User-Agent":         {"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15"},
+		},
+	},
+	{
+		name:       "firefox-linux",
+		tlsProfile: "go",
+		headers: http.Header{
+			"Accept":             {"image/avif,image/webp,*/*"},
+			"Sec-Ch-Ua":          {""},
+			"Sec-Ch-Ua-Mobile":   {""},
+			"Sec-Ch-Ua-Platform": {""},
+			"User-Agent":         {"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"},
+		},
+	},
+}
+
+// personaNames returns the names of all personas.
+func personaNames() []string {
+	names := make([]string, len(personas))
+	for i, p := range personas {
+		names[i] = p.name
+	}
+	return names
+}
+
+// as switches the requests of h to the given persona, keeping the headers given by the user,
+// and returns the function switching them back.
+func (h *hunter) as(p persona) (restore func()) {
+	headers, tlsProfile := h.headers, h.opts.TLSProfile
+	h.headers = make(http.Header, len(headers)+len(p.headers))
+	for k, v := range p.headers {
+		h.headers[k] = v
+	}
+	for k, v := range headers {
+		h.headers[k] = v
+	}
+	h.opts.TLSProfile = p.tlsProfile
+	return func() {
+		h.headers, h.opts.TLSProfile = headers, tlsProfile
+	}
+}
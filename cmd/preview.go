@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"strings"
+)
+
+const previewWidth = 48 // in terminal columns
+
+// printPreview renders a small thumbnail of the given encoded image to the terminal,
+// using ANSI true color escape codes and half blocks (each character cell shows 2 vertical pixels).
+func printPreview(data []byte) error {
+	if !isTerminal(os.Stdout) {
+		return fmt.Errorf("stdout is not a terminal")
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return fmt.Errorf("empty image")
+	}
+	width := previewWidth
+	if bounds.Dx() < width {
+		width = bounds.Dx()
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 2 {
+		height = 2
+	}
+	at := func(x, y int) (uint32, uint32, uint32) { // nearest-neighbor sampling
+		r, g, b, _ := img.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height).RGBA()
+		return r >> 8, g >> 8, b >> 8
+	}
+
+	var sb strings.Builder
+	for y := 0; y+1 < height; y += 2 {
+		for x := 0; x < width; x++ {
+			tr, tg, tb := at(x, y)
+			br, bg, bb := at(x, y+1)
+			sb.WriteString(fmt.Sprintf("\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", tr, tg, tb, br, bg, bb))
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+	_, err = os.Stdout.WriteString(sb.String())
+	return err
+}
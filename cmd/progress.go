@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
+)
+
+// isTerminal returns whether the given file is a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// newProgressBar returns a progress bar rendered to stderr,
+// or a silent one when stderr is not a terminal (e.g. piped to a log file), where its control codes are only clutter.
+func newProgressBar(max int64) *progressbar.ProgressBar {
+	if !isTerminal(os.Stderr) {
+		return progressbar.DefaultSilent(max)
+	}
+	return progressbar.Default(max)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"weibo-image-hound/internal/weibo"
+)
+
+// qualitiesCmd represents the qualities command
+var qualitiesCmd = &cobra.Command{
+	Use:   "qualities",
+	Short: "List all known Weibo image quality tiers",
+	Long: `List all known Weibo image quality tiers, from the highest to the lowest. 
+Hunting starts from the highest tier and goes down until an image is found. 
+Example: weibo-image-hound qualities`,
+	Run: listQualities,
+}
+
+func init() {
+	rootCmd.AddCommand(qualitiesCmd)
+}
+
+func listQualities(cmd *cobra.Command, args []string) {
+	for i, q := range weibo.Qualities() {
+		if i == 0 {
+			fmt.Printf("%2d. %s (default target)\n", i+1, q)
+			continue
+		}
+		fmt.Printf("%2d. %s\n", i+1, q)
+	}
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// huntReport represents the outcome of hunting for a URL.
+type huntReport struct {
+	Time     time.Time
+	URL      string
+	Quality  string
+	IP       probe.ResolvedIP
+	Port     string
+	Status   int
+	Size     int64
+	Duration time.Duration
+	Err      error
+	Certs    []edgeCerts // with --capture-certs
+	SHA256   string      // of the saved image
+	Attempts []attempt
+	Persona  string   // found as, with --rotate-persona, empty for the one of the flags
+	Paths    []string // of the saved files
+}
+
+// writeCSV writes the given reports as CSV to the file at path, or stdout if path is "-".
+func writeCSV(path string, reports []huntReport) error {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create CSV file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"url", "quality", "ip", "country", "status", "size", "duration_ms", "error"})
+	for _, r := range reports {
+		var IP, country, status, size, errMsg string
+		if r.IP.IP != nil {
+			IP = net.JoinHostPort(r.IP.String(), r.Port)
+			country = strings.Join(r.IP.Countries, " ")
+		}
+		if r.Status != 0 {
+			status = strconv.Itoa(r.Status)
+		}
+		if r.Err == nil {
+			size = strconv.FormatInt(r.Size, 10)
+		} else {
+			errMsg = r.Err.Error()
+		}
+		_ = cw.Write([]string{r.URL, r.Quality, IP, country, status, size, strconv.FormatInt(r.Duration.Milliseconds(), 10), errMsg})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// historyEntry is a line of the history file.
+type historyEntry struct {
+	Time       time.Time `json:"time"`
+	URL        string    `json:"url"`
+	Success    bool      `json:"success"`
+	Quality    string    `json:"quality,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	SHA256     string    `json:"sha256,omitempty"`  // of the saved image
+	Persona    string    `json:"persona,omitempty"` // found as, with `hunt --rotate-persona`
+	Paths      []string  `json:"paths,omitempty"`   // of the saved files
+	// Attempts are the outcomes of all requests of the hunt, with "hunt.history_attempts" in config.
+	Attempts []attempt `json:"attempts,omitempty"`
+	// Certificates are the certificate chains presented by the edges, with `hunt --capture-certs`.
+	Certificates []edgeCerts `json:"certificates,omitempty"`
+}
+
+// appendHistory appends the given reports as JSON lines to the history file at path, accumulating across runs.
+func appendHistory(path string, reports []huntReport) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, r := range reports {
+		if err = enc.Encode(historyEntryOf(r)); err != nil {
+			return fmt.Errorf("failed to write history file: %w", err)
+		}
+	}
+	return nil
+}
+
+// historyEntryOf returns the history entry of the given report, which is also its JSON view.
+func historyEntryOf(r huntReport) historyEntry {
+	e := historyEntry{Time: r.Time, URL: r.URL, Success: r.Err == nil, DurationMs: r.Duration.Milliseconds(), Certificates: r.Certs}
+	if config.Hunt.HistoryAttempts { // not only recorded for --explain
+		e.Attempts = r.Attempts
+	}
+	if r.IP.IP != nil {
+		e.IP = net.JoinHostPort(r.IP.String(), r.Port)
+	}
+	if r.Err == nil {
+		e.Quality, e.Size, e.SHA256, e.Persona, e.Paths = r.Quality, r.Size, r.SHA256, r.Persona, r.Paths
+	} else {
+		e.Error = r.Err.Error()
+	}
+	return e
+}
+
+// printMeta prints the JSON view of the given report as a line to stderr, keeping stdout clean for pipelines.
+func printMeta(r huntReport) {
+	if err := json.NewEncoder(os.Stderr).Encode(historyEntryOf(r)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print result: %v\n", err)
+	}
+}
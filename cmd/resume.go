@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// cacheResumeCmd represents the cache resume command
+var cacheResumeCmd = &cobra.Command{
+	Use:   "resume [flags]",
+	Short: "Cache the results of the measurements left unfinished by interrupted cache runs",
+	Long: `Cache the results of the measurements left unfinished by interrupted cache runs. 
+The IDs of created measurements are kept in a state file next to the config file until their results are cached. 
+Example: weibo-image-hound cache resume`,
+	Run: cacheResume,
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheResumeCmd)
+	cacheResumeCmd.Flags().Bool("clear", false, "forget all unfinished measurements instead of resuming them")
+}
+
+func cacheResume(cmd *cobra.Command, args []string) {
+	pending.load(cfgFilePath)
+	if cmd.Flag("clear").Changed {
+		pending.IDs = nil
+		pending.save()
+		fmt.Println("Unfinished measurements cleared.")
+		return
+	}
+	if len(pending.IDs) == 0 {
+		fmt.Println("No unfinished measurements.")
+		return
+	}
+
+	provider, closeProvider := newProvider(cmd)
+	defer closeProvider()
+	m, ok := provider.(interface {
+		Measurement(ID string) ([]probe.ResolvedIP, error)
+	})
+	if !ok {
+		panic(fmt.Errorf("resuming only works with the globalping provider"))
+	}
+	var resolved []probe.ResolvedIP
+	for ID, hostname := range pending.IDs {
+		r, err := m.Measurement(ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to resume measurement %s of \"%s\": %v\n", ID, hostname, err)
+			continue
+		}
+		fmt.Printf("Resolved %s: %d IPs from %d answers.\n", hostname, len(probe.Unique(r)), len(r))
+		resolved = append(resolved, r...)
+	}
+	cacheResolves(resolved, nil, false, 0)
+}
+
+// pending holds the measurements created but not yet cached, persisted in the state file.
+var pending = &pendingMeasurements{}
+
+// pendingMeasurements tracks the created measurements, implementing globalping.Tracker.
+type pendingMeasurements struct {
+	IDs      map[string]string `yaml:"measurements,omitempty"` // ID -> hostname
+	finished map[string]string
+	path     string
+	mu       sync.Mutex
+}
+
+// load reads the pending measurements of the given config file from its state file, if not loaded yet.
+func (p *pendingMeasurements) load(cfgPath string) {
+	if p.path != "" {
+		return
+	}
+	p.path = cfgPath + ".pending"
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			panic(fmt.Errorf("failed to read state file: %w", err))
+		}
+		return
+	}
+	if err = yaml.Unmarshal(b, p); err != nil {
+		panic(fmt.Errorf("failed to parse state file: %w", err))
+	}
+}
+
+// Created records a created measurement to the state file immediately, so it survives an interruption.
+func (p *pendingMeasurements) Created(ID string, hostname string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.IDs == nil {
+		p.IDs = make(map[string]string)
+	}
+	p.IDs[ID] = hostname
+	p.save()
+}
+
+// Finished marks a measurement as finished, to be removed from the state file by flush once its results are cached.
+func (p *pendingMeasurements) Finished(ID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.finished == nil {
+		p.finished = make(map[string]string)
+	}
+	p.finished[ID] = p.IDs[ID]
+}
+
+// flush removes the finished measurements from the state file.
+func (p *pendingMeasurements) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.finished) == 0 {
+		return
+	}
+	for ID := range p.finished {
+		delete(p.IDs, ID)
+	}
+	p.finished = nil
+	p.save()
+}
+
+// save writes the state file, or removes it when there are no pending measurements.
+func (p *pendingMeasurements) save() {
+	if noCfgWrite || p.path == "" {
+		return
+	}
+	if len(p.IDs) == 0 {
+		if err := os.Remove(p.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Failed to remove state file: %v\n", err)
+		}
+		return
+	}
+	b, err := yaml.Marshal(p)
+	if err != nil {
+		panic(fmt.Errorf("failed to marshal state: %w", err))
+	}
+	if err = os.WriteFile(p.path, b, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write state file: %v\n", err)
+	}
+}
@@ -1,25 +1,141 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
+
+	"weibo-image-hound/internal/probe"
+	"weibo-image-hound/internal/probe/dns"
+	"weibo-image-hound/internal/probe/globalping"
 )
 
+const version = "1.0"
+
 var (
 	config      *Config
 	cfgFilePath string
+	profileName string
+	noCfgWrite  bool
+	baseProfile Profile // the top-level profile, stashed while a named profile is active
 )
 
 type Config struct {
-	Cache struct {
-		Locations map[string][]string `yaml:"locations,omitempty,flow"`
-		Resolves  []net.IP            `yaml:"resolves,omitempty,flow"`
-	} `yaml:"cache,omitempty"`
+	Profile `yaml:",inline"`
+	Hunt    struct {
+		CookieFile string            `yaml:"cookie_file,omitempty"`
+		Cookies    map[string]string `yaml:"cookies,omitempty"`
+		// BlacklistThreshold is the number of hunts an IP may serve censored content in before being skipped, 0 to disable.
+		BlacklistThreshold int `yaml:"blacklist_threshold,omitempty"`
+		// StatusCodes are the HTTP status codes counted as a hit, defaults to only 200.
+		StatusCodes []int `yaml:"status_codes,omitempty,flow"`
+		// FileMode and DirMode are the octal permissions of the output files and created directories.
+		FileMode string `yaml:"file_mode,omitempty"`
+		DirMode  string `yaml:"dir_mode,omitempty"`
+		// Allowlist are the CIDR ranges (or single IPs) that IPs must be within to be connected to, empty to allow all.
+		Allowlist []string `yaml:"allowlist,omitempty"`
+		// PlaceholderHashes are the SHA-256 hashes (in hex) of known censored placeholder bodies, in addition to the built-in ones.
+		PlaceholderHashes []string `yaml:"placeholder_hashes,omitempty"`
+		// HistoryFile is the path of the JSON lines file every hunt's outcome is appended to, empty to disable.
+		HistoryFile string `yaml:"history_file,omitempty"`
+		// HistoryDB is the path of the SQLite database every hunt is logged to, empty to disable.
+		HistoryDB string `yaml:"history_db,omitempty"`
+		// HistoryAttempts is whether the outcomes of all requests of each hunt are recorded in the history too.
+		HistoryAttempts bool `yaml:"history_attempts,omitempty"`
+		// MaxBodySize is the maximum size in KiB of a response body read into memory, 0 for the default (64 MiB).
+		MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+		// Extensions are the file extensions of auto-named output files by MIME type, overriding the defaults.
+		Extensions map[string]string `yaml:"extensions,omitempty"`
+	} `yaml:"hunt,omitempty"`
+	// Verify holds the networks resolved IPs must belong to, when verified with `cache --verify`.
+	Verify struct {
+		// Prefixes are the CIDR ranges (or single IPs) of the Weibo CDNs.
+		Prefixes []string `yaml:"prefixes,omitempty"`
+		// ASNs are the numbers of the autonomous systems of the Weibo CDNs, looked up via DNS.
+		ASNs []uint32 `yaml:"asns,omitempty,flow"`
+	} `yaml:"verify,omitempty"`
+	// Profiles are the named profiles selectable by the --profile flag, each with its own cache and provider settings.
+	Profiles map[string]*Profile `yaml:"profiles,omitempty"`
+	// CacheBackend is the name of the backend storing the caches of all profiles, empty for the config file.
+	CacheBackend string `yaml:"cache_backend,omitempty"`
+	// CacheFile is the path of the file the caches are stored in by a backend other than the config file,
+	// defaults to the config file path with the extension of the backend.
+	CacheFile string `yaml:"cache_file,omitempty"`
+}
+
+// Profile holds the cache and provider settings, which can be switched between with named profiles.
+type Profile struct {
+	Cache Cache `yaml:"cache,omitempty"`
+	// Notes are the user notes of IPs, by IP.
+	Notes     map[string]string `yaml:"notes,omitempty"`
+	Providers struct {
+		GlobalPing globalping.Config `yaml:"global_ping,omitempty"`
+		DNS        dns.Config        `yaml:"dns,omitempty"`
+		// Fallback is the ordered list of provider names tried by the "fallback" provider.
+		Fallback []string `yaml:"fallback,omitempty,flow"`
+	} `yaml:"providers,omitempty"`
+}
+
+// Cache holds the resolved IPs and what is known about them.
+// It is stored in the config file, or elsewhere by the backend selected with `cache_backend`.
+type Cache struct {
+	// Locations are the locations that returned IPs in the previous runs, by provider name,
+	// which `cache` resolves from by default.
+	Locations map[string][]string `yaml:"locations,omitempty,flow" json:"locations,omitempty"`
+	// Resolves are the resolved IPs, with where they were resolved from if the provider tells.
+	Resolves []probe.ResolvedIP `yaml:"resolves,omitempty" json:"resolves,omitempty"`
+	Censored map[string]int     `yaml:"censored,omitempty,flow" json:"censored,omitempty"` // IP -> number of hunts it served censored content in
+	// PreferredIPs are the user-curated IPs always tried first by hunts, kept when the resolves are overwritten.
+	PreferredIPs []probe.ResolvedIP `yaml:"preferred_ips,omitempty,flow" json:"preferred_ips,omitempty"`
+	// Resolved is when each hostname was last resolved, by hostname, which `cache --incremental` skips while fresh.
+	Resolved map[string]time.Time `yaml:"resolved,omitempty" json:"resolved,omitempty"`
+	// RotationOffset is the index of the next location to resolve from with `cache --rotate`.
+	RotationOffset int `yaml:"rotation_offset,omitempty" json:"rotation_offset,omitempty"`
+}
+
+// plainCache is Cache without the custom unmarshaling.
+type plainCache Cache
+
+// legacyCache is the cache of older versions, which stored where each IP was resolved from separately.
+type legacyCache struct {
+	plainCache `yaml:",inline"`
+	// Origins are where each IP was resolved from, by IP.
+	Origins map[string]probe.ResolvedIP `yaml:"origins,omitempty" json:"origins,omitempty"`
+}
+
+// cache returns the cache with the origins merged into its resolves.
+func (l legacyCache) cache() Cache {
+	c := Cache(l.plainCache)
+	for i, r := range c.Resolves {
+		if o, ok := l.Origins[r.IP.String()]; ok {
+			c.Resolves[i].Merge(o)
+		}
+	}
+	return c
+}
+
+func (c *Cache) UnmarshalYAML(value *yaml.Node) error {
+	var l legacyCache
+	if err := value.Decode(&l); err != nil {
+		return err
+	}
+	*c = l.cache()
+	return nil
+}
+
+func (c *Cache) UnmarshalJSON(b []byte) error {
+	var l legacyCache
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*c = l.cache()
+	return nil
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -29,34 +145,82 @@ var rootCmd = &cobra.Command{
 	Long: `A tool to hunt for uncensored Weibo images. 
 It will try its best to find an uncensored version of the image by the given URL, 
 by requesting to Weibo image CDNs from different locations across the world.`,
+	Version: version,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		var code exitError
+		if errors.As(err, &code) {
+			os.Exit(int(code))
+		}
 		os.Exit(1)
 	}
 }
 
+// exitError is the error returned by commands to exit with the code once their deferred functions have run,
+// having printed why themselves.
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit code %d", int(e))
+}
+
+// exitWith returns the error for cmd to return to exit with the given code, without cobra printing it or the usage.
+func exitWith(cmd *cobra.Command, code int) error {
+	cmd.SilenceErrors, cmd.SilenceUsage = true, true
+	return exitError(code)
+}
+
 func init() {
-	cobra.OnInitialize(loadConfig, saveConfig)
+	cobra.OnInitialize(loadConfig) // saved by the commands changing it
 
-	rootCmd.PersistentFlags().StringVar(&cfgFilePath, "config", "", "config file (default is $HOME/.weibo-image-hound.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "never color the output (also with the NO_COLOR environment variable)")
+	rootCmd.PersistentFlags().BoolVar(&noCfgWrite, "no-config-write", false, "never write to the config file (for read-only environments)")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "named profile in the config file to use the cache and provider settings of")
+	rootCmd.PersistentFlags().StringVar(&cfgFilePath, "config", "", "config file (default is the nearest "+cfgFileName+" in the current directory or its parents, then $HOME/"+cfgFileName+")")
 	if cfgFilePath == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			panic(fmt.Errorf("failed to get user home directory: %w", err))
+		cfgFilePath = defaultConfigPath()
+	}
+}
+
+const cfgFileName = ".weibo-image-hound.yaml"
+
+// defaultConfigPath returns the path to the config file to use when not specified by the --config flag.
+// The search order is:
+//  1. the current working directory, then each of its parent directories up to the root;
+//  2. the user home directory, where the file will be created if not found anywhere.
+func defaultConfigPath() string {
+	if dir, err := os.Getwd(); err == nil {
+		for {
+			path := filepath.Join(dir, cfgFileName)
+			if f, err := os.Stat(path); err == nil && !f.IsDir() {
+				return path
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir { // reached the root
+				break
+			}
+			dir = parent
 		}
-		cfgFilePath = filepath.Join(home, ".weibo-image-hound.yaml")
 	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		panic(fmt.Errorf("failed to get user home directory: %w", err))
+	}
+	return filepath.Join(home, cfgFileName)
 }
 
 // loadConfig loads the configuration from the file at cfgFilePath.
 func loadConfig() {
 	f, err := os.ReadFile(cfgFilePath)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if os.IsNotExist(err) && noCfgWrite {
+			fmt.Printf("Config file not found at %s, using an empty one.\n", cfgFilePath)
+		} else if os.IsNotExist(err) {
 			fmt.Printf("Config file not found at %s, creating one.\n", cfgFilePath)
 			if _, err = os.Create(cfgFilePath); err != nil {
 				panic(fmt.Errorf("failed to create config file: %w", err))
@@ -66,28 +230,79 @@ func loadConfig() {
 		}
 	}
 
+	config = nil // not merged into the one loaded before, e.g. by each refresh of `cache daemon`
 	if err = yaml.Unmarshal(f, &config); err != nil {
 		panic(fmt.Errorf("failed to parse config file: %w", err))
 	}
 	if config == nil {
 		config = &Config{}
 	}
+	if err = cacheBackendOf(config).load(config); err != nil {
+		panic(fmt.Errorf("failed to load cache: %w", err))
+	}
+	if profileName != "" { // swap in the named profile
+		baseProfile = config.Profile
+		if p, ok := config.Profiles[profileName]; ok && p != nil {
+			config.Profile = *p
+		} else {
+			fmt.Printf("Profile \"%s\" not found, creating it.\n", profileName)
+			config.Profile = Profile{}
+		}
+	}
 }
 
 // saveConfig saves the current configuration to the file at cfgFilePath.
+// The file is replaced atomically, so concurrent runs (e.g. hunts during `cache daemon`) never read a partial one.
+// It does nothing with the --no-config-write flag.
 func saveConfig() {
-	f, err := os.Create(cfgFilePath)
-	if err != nil {
-		panic(fmt.Errorf("failed to create config file: %w", err))
+	if noCfgWrite {
+		return
 	}
-	defer f.Close()
-
-	b, err := yaml.Marshal(config)
+	c := *config
+	if profileName != "" { // swap the named profile back
+		c.Profiles = make(map[string]*Profile, len(config.Profiles)+1)
+		for name, p := range config.Profiles {
+			c.Profiles[name] = p
+		}
+		active := config.Profile
+		c.Profiles[profileName] = &active
+		c.Profile = baseProfile
+	}
+	if err := cacheBackendOf(config).save(&c); err != nil {
+		panic(fmt.Errorf("failed to save cache: %w", err))
+	}
+	b, err := yaml.Marshal(&c)
 	if err != nil {
 		panic(fmt.Errorf("failed to marshal config: %w", err))
 	}
-
-	if _, err = f.Write(b); err != nil {
+	if err = replaceFile(cfgFilePath, b); err != nil {
 		panic(fmt.Errorf("failed to write config file: %w", err))
 	}
 }
+
+// replaceFile atomically replaces the content of the file at path with b, keeping its mode (0644 if new).
+// If path is a symlink, the file it points to is replaced instead.
+func replaceFile(path string, b []byte) error {
+	if p, err := filepath.EvalSymlinks(path); err == nil { // keep the symlink
+		path = p
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+	if _, err = f.Write(b); err == nil {
+		err = f.Chmod(mode)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// configSchemaCmd represents the config schema command
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the config file",
+	Long: `Print the JSON Schema of the config file, generated from the config structure, 
+for validating it and for autocompletion in editors, e.g. with the YAML language server: 
+# yaml-language-server: $schema=./weibo-image-hound.schema.json 
+Example: weibo-image-hound config schema > weibo-image-hound.schema.json`,
+	Hidden: true,
+	Run:    printConfigSchema,
+}
+
+func init() {
+	configCmd.AddCommand(configSchemaCmd)
+}
+
+func printConfigSchema(cmd *cobra.Command, args []string) {
+	s := schemaOf(reflect.TypeOf(Config{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "weibo-image-hound config"
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		panic(fmt.Errorf("failed to marshal schema: %w", err))
+	}
+	fmt.Println(string(b))
+}
+
+var (
+	durationType   = reflect.TypeOf(time.Duration(0))
+	ipType         = reflect.TypeOf(net.IP{})
+	resolvedIPType = reflect.TypeOf(probe.ResolvedIP{})
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// schemaOf returns the JSON Schema of values of type t as decoded from YAML.
+func schemaOf(t reflect.Type) map[string]any {
+	switch t {
+	case durationType:
+		return map[string]any{"type": "string", "pattern": `^(\d+(\.\d+)?(ns|us|µs|ms|s|m|h))+$`}
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case ipType:
+		return map[string]any{"type": "string", "anyOf": []any{map[string]any{"format": "ipv4"}, map[string]any{"format": "ipv6"}}}
+	case resolvedIPType: // the plain IP if nothing else is known about it
+		properties := make(map[string]any)
+		addProperties(properties, t)
+		annotated := map[string]any{"type": "object", "properties": properties, "required": []string{"ip"}, "additionalProperties": false}
+		return map[string]any{"anyOf": []any{schemaOf(ipType), annotated}}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		addProperties(properties, t)
+		return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+	return map[string]any{}
+}
+
+// addProperties adds the schemas of the YAML fields of the struct type t to properties, including those of inlined structs.
+func addProperties(properties map[string]any, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			addProperties(properties, f.Type)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name) // the default of yaml.v3
+		}
+		properties[name] = schemaOf(f.Type)
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/schollz/progressbar/v3"
+
+	"weibo-image-hound/internal/hound"
+	"weibo-image-hound/internal/probe"
+)
+
+// schemePorts are the schemes compared by `hunt --compare-schemes`, with the port requested over each.
+var schemePorts = []struct{ scheme, port string }{{"https", "443"}, {"http", "80"}}
+
+// compareSchemes requests the image of the given quality URL from each of the given IPs over both HTTPS and plain HTTP,
+// prints which schemes served the image from each IP, and returns the selected result among all hits if any.
+func (h *hunter) compareSchemes(URL string, IPs []probe.ResolvedIP, bar *progressbar.ProgressBar) (hound.Result, bool) {
+	ctx, cancel := context.WithCancel(h.cmd.Context())
+	defer cancel()
+	ch := make(chan hound.Result, len(IPs)*len(schemePorts))
+	for _, s := range schemePorts {
+		u, err := withScheme(URL, s.scheme)
+		if err != nil {
+			fmt.Printf("%s Invalid URL %s: %v\n", colorTag(os.Stdout, "[FAILED]"), URL, err)
+			return hound.Result{}, false
+		}
+		go hound.Hunt(ctx, ch, u, []string{s.port}, IPs, h.headers, h.opts)
+	}
+
+	outcomes := make(map[string]map[string]string, len(IPs)) // IP -> scheme -> outcome
+	hits := make(map[string]int, len(schemePorts))
+	selector, _ := hound.NewSelector(h.cmd.Flag("strategy").Value.String())
+	for i := 0; i < cap(ch); i++ {
+		result := <-ch
+		_ = bar.Add(1)
+		h.captureCerts(result)
+		scheme := "https"
+		if u, err := url.Parse(result.URL); err == nil {
+			scheme = u.Scheme
+		}
+		outcome := fmt.Sprintf("OK (%d bytes)", len(result.Body))
+		err := h.check(result)
+		h.recordAttempt(result, err)
+		if err != nil {
+			outcome = err.Error()
+		} else {
+			hits[scheme]++
+			selector.Offer(result)
+		}
+		if outcomes[result.IP.String()] == nil {
+			outcomes[result.IP.String()] = make(map[string]string, len(schemePorts))
+		}
+		outcomes[result.IP.String()][scheme] = outcome
+	}
+
+	addrs := make([]string, 0, len(outcomes))
+	for IP := range outcomes {
+		addrs = append(addrs, IP)
+	}
+	sort.Strings(addrs)
+	for _, IP := range addrs {
+		fmt.Printf("%s | https: %s | http: %s\n", IP, outcomes[IP]["https"], outcomes[IP]["http"])
+	}
+	fmt.Printf("Served %s over HTTPS by %d and over HTTP by %d of %d resolves.\n", URL, hits["https"], hits["http"], len(IPs))
+	return selector.Selected()
+}
+
+// withScheme returns the given URL with the given scheme, on its default port.
+func withScheme(URL string, scheme string) (string, error) {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme, u.Host = scheme, u.Hostname()
+	return u.String(), nil
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // pure Go, so cross-compiling keeps working
+)
+
+// sqlSchema is the schema of the SQLite database every hunt is logged to (see historyDBPath).
+const sqlSchema = `CREATE TABLE IF NOT EXISTS hunts (
+  id          INTEGER PRIMARY KEY,
+  time        TEXT NOT NULL, -- RFC 3339
+  url         TEXT NOT NULL,
+  success     INTEGER NOT NULL,
+  quality     TEXT,
+  ip          TEXT, -- host:port of the winning edge
+  status      INTEGER,
+  size        INTEGER,
+  sha256      TEXT, -- of the saved image
+  duration_ms INTEGER NOT NULL,
+  error       TEXT
+);
+CREATE TABLE IF NOT EXISTS attempts ( -- with "hunt.history_attempts" in config
+  hunt_id INTEGER NOT NULL REFERENCES hunts (id),
+  address TEXT NOT NULL, -- host:port
+  outcome TEXT NOT NULL, -- e.g. OK, HTTP 404, timeout
+  size    INTEGER
+);
+CREATE INDEX IF NOT EXISTS hunts_url ON hunts (url);
+CREATE INDEX IF NOT EXISTS hunts_sha256 ON hunts (sha256);
+CREATE INDEX IF NOT EXISTS attempts_hunt_id ON attempts (hunt_id);
+`
+
+// historyDBPath returns the path of the SQLite database every hunt is logged to ("hunt.history_db" in config),
+// which is the cache database with the sqlite cache backend if not set, or an empty string if disabled.
+func historyDBPath() string {
+	if config.Hunt.HistoryDB == "" && config.CacheBackend == "sqlite" {
+		return cacheFilePath(config, ".db")
+	}
+	return config.Hunt.HistoryDB
+}
+
+// openDB opens the SQLite database at path, creating it with the given schema if needed.
+func openDB(path string, schema string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if _, err = db.Exec("PRAGMA busy_timeout = 5000;" + schema); err != nil { // concurrent runs wait for each other
+		db.Close()
+		return nil, fmt.Errorf("failed to create database: %w", err)
+	}
+	return db, nil
+}
+
+// insertHistory logs the given reports to the history database at path, accumulating across runs.
+func insertHistory(path string, reports []huntReport) error {
+	db, err := openDB(path, sqlSchema)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to write history database: %w", err)
+	}
+	defer tx.Rollback() // no-op once committed
+	for _, r := range reports {
+		e := historyEntryOf(r)
+		res, err := tx.Exec("INSERT INTO hunts (time, url, success, quality, ip, status, size, sha256, duration_ms, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			e.Time.Format(time.RFC3339Nano), e.URL, e.Success, nullString(e.Quality), nullString(e.IP), nullInt(int64(r.Status)),
+			nullInt(e.Size), nullString(e.SHA256), e.DurationMs, nullString(e.Error))
+		if err != nil {
+			return fmt.Errorf("failed to write history database: %w", err)
+		}
+		id, _ := res.LastInsertId()
+		for _, a := range e.Attempts {
+			if _, err = tx.Exec("INSERT INTO attempts VALUES (?, ?, ?, ?)", id, a.Address, a.Outcome, nullInt(int64(a.Size))); err != nil {
+				return fmt.Errorf("failed to write history database: %w", err)
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write history database: %w", err)
+	}
+	return nil
+}
+
+// queryHistory runs the given SQL query on the history database at path, and writes the rows to w
+// tab-separated, after a header of the column names.
+func queryHistory(w io.Writer, path string, query string) error {
+	db, err := openDB(path, sqlSchema)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to query history database: %w", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to query history database: %w", err)
+	}
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	values := make([]any, len(columns))
+	for i := range values {
+		values[i] = new(sql.NullString)
+	}
+	fields := make([]string, len(columns))
+	for rows.Next() {
+		if err = rows.Scan(values...); err != nil {
+			return fmt.Errorf("failed to query history database: %w", err)
+		}
+		for i, v := range values {
+			fields[i] = v.(*sql.NullString).String
+		}
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+	}
+	return rows.Err()
+}
+
+// querySuccesses returns the number of successful hunts of each IP in the history database at path.
+func querySuccesses(path string) (map[string]int, error) {
+	db, err := openDB(path, sqlSchema)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT ip, COUNT(*) FROM hunts WHERE success AND ip IS NOT NULL GROUP BY ip")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history database: %w", err)
+	}
+	defer rows.Close()
+	successes := make(map[string]int)
+	for rows.Next() {
+		var addr string
+		var n int
+		if err = rows.Scan(&addr, &n); err != nil {
+			return nil, fmt.Errorf("failed to query history database: %w", err)
+		}
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			successes[host] += n // of all ports
+		}
+	}
+	return successes, rows.Err()
+}
+
+// nullString returns the given string as an SQL value, NULL if empty.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullInt returns the given integer as an SQL value, NULL if zero.
+func nullInt(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: n != 0}
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats [flags]",
+	Short: "Summarize the outcomes of past hunts from the history file",
+	Long: `Summarize the outcomes of past hunts from the history file ("hunt.history_file" in config). 
+Example: weibo-image-hound stats --since 168h 
+Example: weibo-image-hound stats --query "SELECT date(time), avg(success) FROM hunts WHERE url LIKE '%abc%' GROUP BY 1"`,
+	Run: stats,
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().String("file", "", "history file to read (default from config)")
+	statsCmd.Flags().Duration("since", 0, "only include the hunts within the given duration until now")
+	statsCmd.Flags().Bool("json", false, "print the summary as JSON")
+	statsCmd.Flags().String("query", "", "run the given SQL query on the history database (\"hunt.history_db\" in config, see `stats --schema`) instead, printing the rows tab-separated")
+	statsCmd.Flags().Bool("schema", false, "print the schema of the history database")
+	statsCmd.Flags().Int("top", 5, "number of the most successful IPs and networks to show")
+}
+
+// statsSummary is the summary of the history printed by the stats command.
+type statsSummary struct {
+	Hunts       int            `json:"hunts"`
+	Successes   int            `json:"successes"`
+	SuccessRate float64        `json:"success_rate"`
+	AverageSize int64          `json:"average_size"`
+	Qualities   map[string]int `json:"qualities"` // winning quality -> number of successes
+	TopIPs      []statsCount   `json:"top_ips"`
+	TopNetworks []statsCount   `json:"top_networks"`
+	Days        []statsDay     `json:"days"`
+}
+
+type statsCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Note  string `json:"note,omitempty"`
+}
+
+type statsDay struct {
+	Date      string `json:"date"`
+	Hunts     int    `json:"hunts"`
+	Successes int    `json:"successes"`
+}
+
+func stats(cmd *cobra.Command, args []string) {
+	if cmd.Flag("schema").Changed {
+		fmt.Print(sqlSchema)
+		if historyDBPath() == cacheFilePath(config, ".db") { // along with the caches
+			fmt.Print(cacheSchema)
+		}
+		return
+	}
+	if cmd.Flag("query").Changed {
+		path := historyDBPath()
+		if path == "" {
+			fmt.Println("No history database, set \"hunt.history_db\" in config to log hunts.")
+			return
+		}
+		if err := queryHistory(os.Stdout, path, cmd.Flag("query").Value.String()); err != nil {
+			panic(err)
+		}
+		return
+	}
+	path := config.Hunt.HistoryFile
+	if cmd.Flag("file").Changed {
+		path = cmd.Flag("file").Value.String()
+	}
+	if path == "" {
+		fmt.Println("No history file, set \"hunt.history_file\" in config to record hunts.")
+		return
+	}
+	var since time.Time
+	if d, _ := cmd.Flags().GetDuration("since"); d > 0 {
+		since = time.Now().Add(-d)
+	}
+	entries, err := readHistory(path, since)
+	if err != nil {
+		panic(err)
+	}
+	top, _ := cmd.Flags().GetInt("top")
+	s := summarize(entries, top)
+
+	if cmd.Flag("json").Changed {
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			panic(fmt.Errorf("failed to marshal summary: %w", err))
+		}
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Printf("Hunts: %d, succeeded: %d (%.1f%%), average size: %d bytes\n", s.Hunts, s.Successes, s.SuccessRate*100, s.AverageSize)
+	fmt.Println("Winning qualities:")
+	for _, q := range sortedCounts(s.Qualities, 0) {
+		fmt.Printf("  %s | %d\n", q.Name, q.Count)
+	}
+	fmt.Println("Most successful IPs:")
+	for _, c := range s.TopIPs {
+		if c.Note != "" {
+			fmt.Printf("  %s | %d | %s\n", c.Name, c.Count, c.Note)
+		} else {
+			fmt.Printf("  %s | %d\n", c.Name, c.Count)
+		}
+	}
+	fmt.Println("Most successful networks:")
+	for _, c := range s.TopNetworks {
+		fmt.Printf("  %s | %d\n", c.Name, c.Count)
+	}
+	fmt.Println("By day:")
+	for _, d := range s.Days {
+		fmt.Printf("  %s | %d/%d\n", d.Date, d.Successes, d.Hunts)
+	}
+}
+
+// readHistory reads the entries of the history file at path since the given time.
+func readHistory(path string, since time.Time) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e historyEntry
+		if err = json.Unmarshal(sc.Bytes(), &e); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipped invalid line %d of history file: %v\n", n, err)
+			continue
+		}
+		if e.Time.Before(since) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err = sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+	return entries, nil
+}
+
+// summarize returns the summary of the given history entries, with the given number of the most successful IPs and networks.
+func summarize(entries []historyEntry, top int) statsSummary {
+	s := statsSummary{Hunts: len(entries), Qualities: make(map[string]int)}
+	IPs, networks := make(map[string]int), make(map[string]int)
+	days := make(map[string]*statsDay)
+	var totalSize int64
+	for _, e := range entries {
+		date := e.Time.Local().Format(time.DateOnly)
+		d, ok := days[date]
+		if !ok {
+			d = &statsDay{Date: date}
+			days[date] = d
+		}
+		d.Hunts++
+		if !e.Success {
+			continue
+		}
+		d.Successes++
+		s.Successes++
+		totalSize += e.Size
+		if e.Quality != "" {
+			s.Qualities[e.Quality]++
+		}
+		if host, _, err := net.SplitHostPort(e.IP); err == nil {
+			IPs[host]++
+			if IP := net.ParseIP(host); IP != nil {
+				networks[networkOf(IP)]++
+			}
+		}
+	}
+	if s.Hunts > 0 {
+		s.SuccessRate = float64(s.Successes) / float64(s.Hunts)
+	}
+	if s.Successes > 0 {
+		s.AverageSize = totalSize / int64(s.Successes)
+	}
+	s.TopIPs, s.TopNetworks = sortedCounts(IPs, top), sortedCounts(networks, top)
+	for i := range s.TopIPs {
+		s.TopIPs[i].Note = config.Notes[s.TopIPs[i].Name]
+	}
+	for _, d := range days {
+		s.Days = append(s.Days, *d)
+	}
+	sort.Slice(s.Days, func(i, j int) bool { return s.Days[i].Date < s.Days[j].Date })
+	return s
+}
+
+// sortedCounts returns the given counts sorted from the highest, limited to the given number if positive.
+func sortedCounts(counts map[string]int, limit int) []statsCount {
+	r := make([]statsCount, 0, len(counts))
+	for name, count := range counts {
+		r = append(r, statsCount{Name: name, Count: count})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Count != r[j].Count {
+			return r[i].Count > r[j].Count
+		}
+		return r[i].Name < r[j].Name
+	})
+	if limit > 0 && len(r) > limit {
+		r = r[:limit]
+	}
+	return r
+}
@@ -0,0 +1,540 @@
+package cmd
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// cacheBackend stores the caches of all profiles, outside the config file or in it.
+type cacheBackend interface {
+	// load sets the caches of the profiles in c from the store.
+	// The caches already in c are kept if the store doesn't exist yet, which migrates them to it on the next save.
+	load(c *Config) error
+	// save stores the caches of the profiles in c, only writing what has changed, and clears them from c if not stored
+	// in the config file.
+	// c must be a shallow copy of the config, as its profiles are replaced.
+	save(c *Config) error
+}
+
+// cacheBackends are the available cache backends, by name.
+var cacheBackends = map[string]cacheBackend{
+	"config": configBackend{},
+	"json":   jsonBackend{},
+	"sqlite": sqliteBackend{},
+}
+
+// cacheBackendOf returns the cache backend selected in c, which is the config file if unset or unknown.
+func cacheBackendOf(c *Config) cacheBackend {
+	if b, ok := cacheBackends[c.CacheBackend]; ok {
+		return b
+	}
+	if c.CacheBackend != "" {
+		fmt.Fprintf(os.Stderr, "Unknown cache backend \"%s\", storing the cache in the config file.\n", c.CacheBackend)
+	}
+	return configBackend{}
+}
+
+// cacheFilePath returns the path of the file the caches are stored in, with the given extension if not configured.
+func cacheFilePath(c *Config, ext string) string {
+	if c.CacheFile != "" {
+		return c.CacheFile
+	}
+	return strings.TrimSuffix(cfgFilePath, filepath.Ext(cfgFilePath)) + ".cache" + ext
+}
+
+// configBackend stores the caches in the config file, along with everything else.
+type configBackend struct{}
+
+func (configBackend) load(*Config) error { return nil }
+
+func (configBackend) save(*Config) error { return nil }
+
+// jsonBackend stores the caches in a JSON file, by profile name ("" for the top-level one),
+// which is much faster to parse than YAML for large caches.
+type jsonBackend struct{}
+
+func (jsonBackend) load(c *Config) error {
+	path := cacheFilePath(c, ".json")
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if len(c.Cache.Resolves) > 0 {
+			fmt.Printf("Migrating the cache to %s.\n", path)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read cache file: %w", err)
+	}
+	var caches map[string]*Cache
+	if err = json.Unmarshal(b, &caches); err != nil {
+		return fmt.Errorf("failed to parse cache file: %w", err)
+	}
+	setCaches(c, caches)
+	return nil
+}
+
+func (jsonBackend) save(c *Config) error {
+	b, err := json.Marshal(takeCaches(c))
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache: %w", err)
+	}
+	b = append(b, '\n')
+	path := cacheFilePath(c, ".json")
+	if stored, err := os.ReadFile(path); err == nil && bytes.Equal(stored, b) {
+		return nil // unchanged
+	}
+	if err = replaceFile(path, b); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	return nil
+}
+
+// setCaches sets the caches of the profiles in c from the given ones, by profile name ("" for the top-level one),
+// creating the profiles not in c.
+func setCaches(c *Config, caches map[string]*Cache) {
+	for name, cache := range caches {
+		if cache == nil {
+			continue
+		}
+		if name == "" {
+			c.Cache = *cache
+		} else if p := c.Profiles[name]; p != nil {
+			p.Cache = *cache
+		} else {
+			if c.Profiles == nil {
+				c.Profiles = make(map[string]*Profile)
+			}
+			c.Profiles[name] = &Profile{Cache: *cache}
+		}
+	}
+}
+
+// takeCaches returns the caches of the profiles in c by profile name ("" for the top-level one), and clears them from c.
+func takeCaches(c *Config) map[string]*Cache {
+	caches := make(map[string]*Cache, len(c.Profiles)+1)
+	top := c.Cache
+	caches[""] = &top
+	profiles := make(map[string]*Profile, len(c.Profiles))
+	for name, p := range c.Profiles {
+		if p == nil {
+			continue
+		}
+		caches[name] = &p.Cache
+		stripped := *p
+		stripped.Cache = Cache{}
+		profiles[name] = &stripped
+	}
+	c.Cache = Cache{}
+	if c.Profiles != nil {
+		c.Profiles = profiles
+	}
+	return caches
+}
+
+// cacheSchema is the schema of the SQLite database the caches are stored in by the sqlite backend, by profile name
+// ("" for the top-level one). The hunts are logged to the same database unless "hunt.history_db" is set.
+const cacheSchema = `CREATE TABLE IF NOT EXISTS profiles (
+  profile         TEXT PRIMARY KEY,
+  rotation_offset INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS resolves (
+  profile  TEXT NOT NULL,
+  position INTEGER NOT NULL,
+  ip       TEXT NOT NULL,
+  PRIMARY KEY (profile, position)
+);
+CREATE TABLE IF NOT EXISTS preferred_ips (
+  profile  TEXT NOT NULL,
+  position INTEGER NOT NULL,
+  ip       TEXT NOT NULL,
+  PRIMARY KEY (profile, position)
+);
+CREATE TABLE IF NOT EXISTS locations (
+  profile  TEXT NOT NULL,
+  provider TEXT NOT NULL,
+  position INTEGER NOT NULL,
+  location TEXT NOT NULL,
+  PRIMARY KEY (profile, provider, position)
+);
+CREATE TABLE IF NOT EXISTS censored (
+  profile TEXT NOT NULL,
+  ip      TEXT NOT NULL,
+  count   INTEGER NOT NULL, -- of the hunts it served censored content in
+  PRIMARY KEY (profile, ip)
+);
+CREATE TABLE IF NOT EXISTS origins (
+  profile TEXT NOT NULL,
+  ip      TEXT NOT NULL,
+  local   INTEGER NOT NULL, -- resolved by the local resolver too
+  PRIMARY KEY (profile, ip)
+);
+CREATE TABLE IF NOT EXISTS origin_places (
+  profile TEXT NOT NULL,
+  ip      TEXT NOT NULL,
+  kind    TEXT NOT NULL, -- country or region
+  place   TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS origin_asns (
+  profile TEXT NOT NULL,
+  ip      TEXT NOT NULL,
+  asn     INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS resolved (
+  profile  TEXT NOT NULL,
+  hostname TEXT NOT NULL,
+  time     TEXT NOT NULL, -- RFC 3339
+  PRIMARY KEY (profile, hostname)
+);
+CREATE INDEX IF NOT EXISTS origin_places_ip ON origin_places (profile, ip);
+CREATE INDEX IF NOT EXISTS origin_asns_ip ON origin_asns (profile, ip);
+`
+
+// sqliteBackend stores the caches in an SQLite database, in a table for each kind of what is known about the IPs,
+// which keeps large caches fast and queryable.
+type sqliteBackend struct{}
+
+func (sqliteBackend) load(c *Config) error {
+	path := cacheFilePath(c, ".db")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if len(c.Cache.Resolves) > 0 {
+			fmt.Printf("Migrating the cache to %s.\n", path)
+		}
+		return nil
+	}
+	db, err := openDB(path, cacheSchema)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	caches := make(map[string]*Cache)
+	cacheOf := func(profile string) *Cache {
+		if caches[profile] == nil {
+			caches[profile] = &Cache{}
+		}
+		return caches[profile]
+	}
+	origins := make(map[string]map[string]*probe.ResolvedIP) // profile -> IP -> what is known about it
+	originOf := func(profile string, IP string) *probe.ResolvedIP {
+		if origins[profile] == nil {
+			origins[profile] = make(map[string]*probe.ResolvedIP)
+		}
+		if origins[profile][IP] == nil {
+			origins[profile][IP] = &probe.ResolvedIP{}
+		}
+		return origins[profile][IP]
+	}
+	queries := []struct {
+		query string
+		scan  func(scan func(...any) error) error
+	}{
+		{"SELECT profile, rotation_offset FROM profiles", func(scan func(...any) error) error {
+			var profile string
+			var offset int
+			err := scan(&profile, &offset)
+			cacheOf(profile).RotationOffset = offset
+			return err
+		}},
+		{"SELECT profile, ip FROM resolves ORDER BY profile, position", func(scan func(...any) error) error {
+			var profile, IP string
+			err := scan(&profile, &IP)
+			cache := cacheOf(profile)
+			cache.Resolves = append(cache.Resolves, probe.ResolvedIP{IP: net.ParseIP(IP)})
+			return err
+		}},
+		{"SELECT profile, ip FROM preferred_ips ORDER BY profile, position", func(scan func(...any) error) error {
+			var profile, IP string
+			err := scan(&profile, &IP)
+			cache := cacheOf(profile)
+			cache.PreferredIPs = append(cache.PreferredIPs, probe.ResolvedIP{IP: net.ParseIP(IP)})
+			return err
+		}},
+		{"SELECT profile, provider, location FROM locations ORDER BY profile, provider, position", func(scan func(...any) error) error {
+			var profile, provider, location string
+			err := scan(&profile, &provider, &location)
+			cache := cacheOf(profile)
+			if cache.Locations == nil {
+				cache.Locations = make(map[string][]string)
+			}
+			cache.Locations[provider] = append(cache.Locations[provider], location)
+			return err
+		}},
+		{"SELECT profile, ip, count FROM censored", func(scan func(...any) error) error {
+			var profile, IP string
+			var count int
+			err := scan(&profile, &IP, &count)
+			cache := cacheOf(profile)
+			if cache.Censored == nil {
+				cache.Censored = make(map[string]int)
+			}
+			cache.Censored[IP] = count
+			return err
+		}},
+		{"SELECT profile, ip, local FROM origins", func(scan func(...any) error) error {
+			var profile, IP string
+			var local bool
+			err := scan(&profile, &IP, &local)
+			originOf(profile, IP).Local = local
+			return err
+		}},
+		{"SELECT profile, ip, kind, place FROM origin_places ORDER BY rowid", func(scan func(...any) error) error {
+			var profile, IP, kind, place string
+			err := scan(&profile, &IP, &kind, &place)
+			o := originOf(profile, IP)
+			if kind == "region" {
+				o.Regions = append(o.Regions, place)
+			} else {
+				o.Countries = append(o.Countries, place)
+			}
+			return err
+		}},
+		{"SELECT profile, ip, asn FROM origin_asns ORDER BY rowid", func(scan func(...any) error) error {
+			var profile, IP string
+			var ASN uint32
+			err := scan(&profile, &IP, &ASN)
+			o := originOf(profile, IP)
+			o.ASNs = append(o.ASNs, ASN)
+			return err
+		}},
+		{"SELECT profile, hostname, time FROM resolved", func(scan func(...any) error) error {
+			var profile, hostname, at string
+			if err := scan(&profile, &hostname, &at); err != nil {
+				return err
+			}
+			t, err := time.Parse(time.RFC3339Nano, at)
+			cache := cacheOf(profile)
+			if cache.Resolved == nil {
+				cache.Resolved = make(map[string]time.Time)
+			}
+			cache.Resolved[hostname] = t
+			return err
+		}},
+	}
+	for _, q := range queries {
+		rows, err := db.Query(q.query)
+		if err != nil {
+			return fmt.Errorf("failed to read cache database: %w", err)
+		}
+		for rows.Next() {
+			if err = q.scan(rows.Scan); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read cache database: %w", err)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read cache database: %w", err)
+		}
+	}
+	for profile, cache := range caches {
+		for i, r := range cache.Resolves {
+			if o := origins[profile][r.IP.String()]; o != nil {
+				cache.Resolves[i].Merge(*o)
+			}
+		}
+	}
+	setCaches(c, caches)
+	return nil
+}
+
+func (sqliteBackend) save(c *Config) error {
+	db, err := openDB(cacheFilePath(c, ".db"), cacheSchema)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to write cache database: %w", err)
+	}
+	defer tx.Rollback() // no-op once committed
+	caches := takeCaches(c)
+	for _, t := range cacheTables {
+		if err = t.sync(tx, caches); err != nil {
+			return fmt.Errorf("failed to write cache database: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write cache database: %w", err)
+	}
+	return nil
+}
+
+// cacheTable is a table of the cache database.
+type cacheTable struct {
+	name    string
+	columns []string
+	keys    int // the number of leading columns making up the key of a row, all of them if none is updatable
+	// rows returns the rows storing the cache of the named profile, each a value of every column.
+	rows func(profile string, cache *Cache) [][]any
+}
+
+// cacheTables are the tables of the cache database, all of which the caches are stored in.
+var cacheTables = []cacheTable{
+	{"profiles", []string{"profile", "rotation_offset"}, 1, func(profile string, cache *Cache) [][]any {
+		return [][]any{{profile, cache.RotationOffset}}
+	}},
+	{"resolves", []string{"profile", "position", "ip"}, 2, func(profile string, cache *Cache) [][]any {
+		rows := make([][]any, 0, len(cache.Resolves))
+		for i, IP := range cache.Resolves {
+			rows = append(rows, []any{profile, i, IP.String()})
+		}
+		return rows
+	}},
+	{"preferred_ips", []string{"profile", "position", "ip"}, 2, func(profile string, cache *Cache) [][]any {
+		rows := make([][]any, 0, len(cache.PreferredIPs))
+		for i, IP := range cache.PreferredIPs {
+			rows = append(rows, []any{profile, i, IP.String()})
+		}
+		return rows
+	}},
+	{"locations", []string{"profile", "provider", "position", "location"}, 3, func(profile string, cache *Cache) [][]any {
+		var rows [][]any
+		for provider, locations := range cache.Locations {
+			for i, location := range locations {
+				rows = append(rows, []any{profile, provider, i, location})
+			}
+		}
+		return rows
+	}},
+	{"censored", []string{"profile", "ip", "count"}, 2, func(profile string, cache *Cache) [][]any {
+		rows := make([][]any, 0, len(cache.Censored))
+		for IP, count := range cache.Censored {
+			rows = append(rows, []any{profile, IP, count})
+		}
+		return rows
+	}},
+	{"origins", []string{"profile", "ip", "local"}, 2, func(profile string, cache *Cache) [][]any {
+		var rows [][]any
+		for _, r := range cache.Resolves {
+			if r.Annotated() {
+				rows = append(rows, []any{profile, r.IP.String(), r.Local})
+			}
+		}
+		return rows
+	}},
+	{"origin_places", []string{"profile", "ip", "kind", "place"}, 4, func(profile string, cache *Cache) [][]any {
+		var rows [][]any
+		for _, r := range cache.Resolves {
+			for _, country := range r.Countries {
+				rows = append(rows, []any{profile, r.IP.String(), "country", country})
+			}
+			for _, region := range r.Regions {
+				rows = append(rows, []any{profile, r.IP.String(), "region", region})
+			}
+		}
+		return rows
+	}},
+	{"origin_asns", []string{"profile", "ip", "asn"}, 3, func(profile string, cache *Cache) [][]any {
+		var rows [][]any
+		for _, r := range cache.Resolves {
+			for _, ASN := range r.ASNs {
+				rows = append(rows, []any{profile, r.IP.String(), ASN})
+			}
+		}
+		return rows
+	}},
+	{"resolved", []string{"profile", "hostname", "time"}, 2, func(profile string, cache *Cache) [][]any {
+		rows := make([][]any, 0, len(cache.Resolved))
+		for hostname, t := range cache.Resolved {
+			rows = append(rows, []any{profile, hostname, t.Format(time.RFC3339Nano)})
+		}
+		return rows
+	}},
+}
+
+// sync makes the table store the given caches by profile name, only upserting the rows missing or changed in it,
+// and deleting the ones no longer there.
+func (t cacheTable) sync(tx *sql.Tx, caches map[string]*Cache) error {
+	stored, err := t.stored(tx)
+	if err != nil {
+		return err
+	}
+	upsert := fmt.Sprintf("INSERT INTO %s VALUES (?%s)", t.name, strings.Repeat(", ?", len(t.columns)-1))
+	if t.keys < len(t.columns) {
+		set := make([]string, 0, len(t.columns)-t.keys)
+		for _, column := range t.columns[t.keys:] {
+			set = append(set, column+" = excluded."+column)
+		}
+		upsert += fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(t.columns[:t.keys], ", "), strings.Join(set, ", "))
+	}
+	for profile, cache := range caches {
+		for _, row := range t.rows(profile, cache) {
+			text := textOf(row)
+			key := strings.Join(text[:t.keys], "\x00")
+			if s, ok := stored[key]; ok {
+				delete(stored, key)
+				if slices.Equal(s, text) {
+					continue
+				}
+			}
+			if _, err = tx.Exec(upsert, row...); err != nil {
+				return err
+			}
+		}
+	}
+	conditions := make([]string, 0, t.keys)
+	for _, column := range t.columns[:t.keys] {
+		conditions = append(conditions, column+" = ?")
+	}
+	remove := fmt.Sprintf("DELETE FROM %s WHERE %s", t.name, strings.Join(conditions, " AND "))
+	for _, s := range stored {
+		args := make([]any, 0, t.keys)
+		for _, v := range s[:t.keys] {
+			args = append(args, v)
+		}
+		if _, err = tx.Exec(remove, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stored returns the rows stored in the table as text, by their keys.
+func (t cacheTable) stored(tx *sql.Tx) (map[string][]string, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT %s FROM %s", strings.Join(t.columns, ", "), t.name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stored := make(map[string][]string)
+	for rows.Next() {
+		text := make([]string, len(t.columns))
+		dest := make([]any, len(text))
+		for i := range text {
+			dest[i] = &text[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		stored[strings.Join(text[:t.keys], "\x00")] = text
+	}
+	return stored, rows.Err()
+}
+
+// textOf returns the given values as the text SQLite stores them as, booleans as integers.
+func textOf(values []any) []string {
+	text := make([]string, 0, len(values))
+	for _, v := range values {
+		if b, ok := v.(bool); ok {
+			v = 0
+			if b {
+				v = 1
+			}
+		}
+		text = append(text, fmt.Sprint(v))
+	}
+	return text
+}
@@ -0,0 +1,8 @@
+package cmd
+
+import "os"
+
+// umask returns the file mode creation mask of the process, which Windows doesn't have.
+func umask() os.FileMode {
+	return 0
+}
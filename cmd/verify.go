@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"weibo-image-hound/internal/asn"
+	"weibo-image-hound/internal/probe"
+)
+
+const (
+	asnLookupTimeout     = 5 * time.Second
+	asnLookupConcurrency = 16
+)
+
+// verifyResolves returns the given resolved IPs within the prefixes or ASNs of "verify" in config,
+// annotated with their ASNs if looked up, and reports how many were discarded.
+func verifyResolves(ctx context.Context, resolved []probe.ResolvedIP) []probe.ResolvedIP {
+	if len(config.Verify.Prefixes) == 0 && len(config.Verify.ASNs) == 0 {
+		panic(fmt.Errorf("no prefixes or ASNs to verify against, please set \"verify\" in config"))
+	}
+	networks, err := parseNetworks(config.Verify.Prefixes)
+	if err != nil {
+		panic(fmt.Errorf("invalid verify prefix: %w", err))
+	}
+
+	// look up each unique IP not within the prefixes only once
+	verified := make(map[string]bool)
+	ASNsOf := make(map[string][]uint32)
+	var lookups []probe.ResolvedIP
+	for _, a := range resolved {
+		if _, ok := verified[a.IP.String()]; ok {
+			continue
+		}
+		verified[a.IP.String()] = containsIP(networks, a.IP)
+		if !verified[a.IP.String()] && len(config.Verify.ASNs) > 0 {
+			lookups = append(lookups, a)
+		}
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, asnLookupConcurrency)
+	for _, a := range lookups {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(a probe.ResolvedIP) {
+			defer func() { <-sem; wg.Done() }()
+			ctx, cancel := context.WithTimeout(ctx, asnLookupTimeout)
+			defer cancel()
+			ASNs, err := asn.Lookup(ctx, a.IP)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to verify %s: %v\n", a.IP.String(), err)
+				return
+			}
+			ok := slices.ContainsFunc(ASNs, func(n uint32) bool { return slices.Contains(config.Verify.ASNs, n) })
+			mu.Lock()
+			verified[a.IP.String()], ASNsOf[a.IP.String()] = ok, ASNs
+			mu.Unlock()
+		}(a)
+	}
+	wg.Wait()
+
+	r := make([]probe.ResolvedIP, 0, len(resolved))
+	discarded := make(map[string]struct{})
+	for _, a := range resolved {
+		if !verified[a.IP.String()] {
+			discarded[a.IP.String()] = struct{}{}
+			continue
+		}
+		if ASNs, ok := ASNsOf[a.IP.String()]; ok {
+			a.ASNs = ASNs
+		}
+		r = append(r, a)
+	}
+	fmt.Printf("Discarded %d of %d unique IPs outside the verified networks.\n", len(discarded), len(verified))
+	return r
+}
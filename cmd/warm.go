@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"weibo-image-hound/internal/hound"
+	"weibo-image-hound/internal/probe"
+)
+
+// warmCmd represents the cache warm command
+var warmCmd = &cobra.Command{
+	Use:   "warm --url URL [--url URL]... [flags]",
+	Short: "Cache the IP addresses that actually serve the given sample images",
+	Long: `Cache the IP addresses that actually serve the given sample images. 
+The hostnames of the samples are resolved with the provider, then the samples are hunted across the resolved and 
+already cached IPs, and only the IPs that served the samples successfully are cached. 
+Example: weibo-image-hound cache warm --url https://wx4.sinaimg.cn/mw2000/c49cf6fdgy1hjwxqm5ctrj20k04zytjs.jpg`,
+	Run: warm,
+}
+
+func init() {
+	cacheCmd.AddCommand(warmCmd)
+	warmCmd.Flags().StringArray("url", nil, "URL of a sample image (repeatable)")
+	warmCmd.Flags().BoolP("force", "f", false, "force overwrite existing cached resolves")
+	warmCmd.Flags().Bool("list", false, "list the IPs that served each sample, ordered to spread across networks and regions")
+}
+
+func warm(cmd *cobra.Command, args []string) {
+	samples, _ := cmd.Flags().GetStringArray("url")
+	if len(samples) == 0 {
+		_ = cmd.Help()
+		return
+	}
+
+	provider, closeProvider := newProvider(cmd)
+	defer closeProvider()
+	locations, err := provider.Locations()
+	if err != nil {
+		panic(fmt.Errorf("failed to get locations: %w", err))
+	}
+	locations = unique(locations)
+
+	type sample struct {
+		URL  string
+		port string
+	}
+	var parsed []sample
+	candidates := append([]probe.ResolvedIP(nil), config.Cache.Resolves...)
+	resolved := make(map[string]struct{})
+	for _, URL := range samples {
+		u, err := parseURL(URL)
+		if err != nil {
+			panic(fmt.Errorf("invalid URL \"%s\": %w", URL, err))
+		}
+		if err = checkHostname(u.Hostname()); err != nil {
+			panic(err)
+		}
+		parsed = append(parsed, sample{URL: URL, port: u.Port()})
+		if _, ok := resolved[u.Hostname()]; ok {
+			continue
+		}
+		resolved[u.Hostname()] = struct{}{}
+		IPs, err := provider.Resolve(u.Hostname(), locations)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to resolve \"%s\": %v\n", u.Hostname(), err)
+			continue
+		}
+		fmt.Printf("Resolved %s: %d IPs from %d answers.\n", u.Hostname(), len(probe.Unique(IPs)), len(IPs))
+		candidates = append(candidates, IPs...)
+	}
+	candidates = filterAllowed(probe.Unique(candidates))
+	fmt.Printf("Hunting %d samples across %d IPs.\n", len(parsed), len(candidates))
+
+	opts := hound.Options{StatusCodes: config.Hunt.StatusCodes, PlaceholderHashes: config.Hunt.PlaceholderHashes}
+	var served []probe.ResolvedIP
+	for _, s := range parsed {
+		ctx, cancel := context.WithCancel(cmd.Context())
+		ch := make(chan hound.Result, len(candidates))
+		go hound.Hunt(ctx, ch, s.URL, []string{s.port}, candidates, nil, opts)
+		var results []hound.Result
+		for range candidates {
+			if r := <-ch; opts.Hit(r) { // not e.g. a known placeholder
+				served = append(served, r.IP)
+				results = append(results, r)
+			}
+		}
+		cancel()
+		fmt.Printf("%d IPs served %s\n", len(results), s.URL)
+		if cmd.Flag("list").Changed {
+			for _, r := range hound.Diverse(results, networkOfResult, regionOfResult) {
+				fmt.Printf("  %s | %s | %s\n", r.IP.String(), networkOfResult(r), regionOfResult(r))
+			}
+		}
+	}
+
+	resolves := config.Cache.Resolves
+	if cmd.Flag("force").Changed { // force overwrite
+		resolves = nil
+	}
+	config.Cache.Resolves = probe.Unique(append(resolves, served...))
+	saveConfig()
+	pending.flush()
+	fmt.Printf("Cached %d resolves.\n", len(config.Cache.Resolves))
+}
+
+// networkOfResult returns the network the IP of the given result belongs to.
+func networkOfResult(r hound.Result) string {
+	return networkOf(r.IP.IP)
+}
+
+// regionOfResult returns the regions the IP of the given result was resolved from, if known.
+func regionOfResult(r hound.Result) string {
+	return strings.Join(r.IP.Regions, ", ")
+}
@@ -0,0 +1,46 @@
+package asn
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Lookup returns the numbers of the autonomous systems originating the given IP,
+// looked up with the IP to ASN mapping DNS service of Team Cymru (https://www.team-cymru.com/ip-asn-mapping).
+func Lookup(ctx context.Context, IP net.IP) ([]uint32, error) {
+	var name string
+	if IP4 := IP.To4(); IP4 != nil {
+		name = fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", IP4[3], IP4[2], IP4[1], IP4[0])
+	} else if IP16 := IP.To16(); IP16 != nil {
+		nibbles := hex.EncodeToString(IP16)
+		var sb strings.Builder
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			sb.WriteByte(nibbles[i])
+			sb.WriteByte('.')
+		}
+		name = sb.String() + "origin6.asn.cymru.com"
+	} else {
+		return nil, fmt.Errorf("invalid IP: %v", IP)
+	}
+
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up ASN: %w", err)
+	}
+	// each record is like "4134 4837 | 1.2.3.0/24 | CN | apnic | 2002-01-01"
+	var ASNs []uint32
+	for _, r := range records {
+		for _, f := range strings.Fields(strings.SplitN(r, "|", 2)[0]) {
+			n, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid record: %s", r)
+			}
+			ASNs = append(ASNs, uint32(n))
+		}
+	}
+	return ASNs, nil
+}
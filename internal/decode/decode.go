@@ -0,0 +1,53 @@
+package decode
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// ErrBrotli is wrapped by the errors of reading corrupt brotli data, after which the request may be retried without brotli.
+var ErrBrotli = errors.New("failed to decode brotli")
+
+// Body wraps the given response body reader to decode it according to the given "content-encoding" header value.
+// Multiple encodings (e.g. "gzip, br") are decoded in reverse order of which they were applied.
+func Body(r io.Reader, contentEncoding string) (io.Reader, error) {
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch e := strings.ToLower(strings.TrimSpace(encodings[i])); e {
+		case "", "identity":
+		case "br":
+			r = &brotliReader{brotli.NewReader(r)}
+		case "gzip", "x-gzip":
+			if r, err = gzip.NewReader(r); err != nil {
+				return nil, fmt.Errorf("failed to decode gzip: %w", err)
+			}
+		case "deflate":
+			if r, err = zlib.NewReader(r); err != nil {
+				return nil, fmt.Errorf("failed to decode deflate: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %s", e)
+		}
+	}
+	return r, nil
+}
+
+// brotliReader wraps the decoding errors of a brotli reader with ErrBrotli.
+type brotliReader struct {
+	r *brotli.Reader
+}
+
+func (r *brotliReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %w", ErrBrotli, err)
+	}
+	return n, err
+}
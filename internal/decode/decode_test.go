@@ -0,0 +1,93 @@
+package decode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encode returns data encoded with the given encodings applied in order, as listed in a "content-encoding" header.
+func encode(t *testing.T, data []byte, encodings ...string) []byte {
+	t.Helper()
+	for _, e := range encodings {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch e {
+		case "br":
+			w = brotli.NewWriter(&buf)
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		default:
+			t.Fatalf("unknown encoding %s", e)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data = buf.Bytes()
+	}
+	return data
+}
+
+func TestBody(t *testing.T) {
+	plain := []byte(strings.Repeat("weibo image hound ", 64))
+	tests := []struct {
+		name            string
+		contentEncoding string
+		encodings       []string // applied to the body
+		wantErr         bool
+	}{
+		{name: "none", contentEncoding: ""},
+		{name: "identity", contentEncoding: "identity"},
+		{name: "br", contentEncoding: "br", encodings: []string{"br"}},
+		{name: "gzip", contentEncoding: "gzip", encodings: []string{"gzip"}},
+		{name: "deflate", contentEncoding: "deflate", encodings: []string{"deflate"}},
+		{name: "uppercase", contentEncoding: "GZIP", encodings: []string{"gzip"}},
+		{name: "gzip, br", contentEncoding: "gzip, br", encodings: []string{"gzip", "br"}},
+		{name: "br,gzip", contentEncoding: "br,gzip", encodings: []string{"br", "gzip"}},
+		{name: "deflate, gzip, br", contentEncoding: "deflate, gzip, br", encodings: []string{"deflate", "gzip", "br"}},
+		{name: "unknown", contentEncoding: "zstd", wantErr: true},
+		{name: "unknown stacked", contentEncoding: "gzip, compress", encodings: []string{"gzip"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Body(bytes.NewReader(encode(t, plain, tt.encodings...)), tt.contentEncoding)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("want error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("got %q, want %q", got, plain)
+			}
+		})
+	}
+}
+
+func TestBodyCorruptBrotli(t *testing.T) {
+	r, err := Body(strings.NewReader("not brotli at all"), "br")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(r); !errors.Is(err, ErrBrotli) {
+		t.Errorf("got error %v, want ErrBrotli", err)
+	}
+}
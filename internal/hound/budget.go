@@ -0,0 +1,60 @@
+package hound
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// ErrBudgetExceeded is the error of requests made or read after the bytes received exceeded the budget (see Options.Budget).
+var ErrBudgetExceeded = errors.New("byte budget exceeded")
+
+// Budget limits the total bytes of response bodies received by the requests sharing it, safe for concurrent use.
+// The bytes are counted as received over the wire, before decoding.
+type Budget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// NewBudget returns a budget of the given bytes.
+func NewBudget(limit int64) *Budget {
+	return &Budget{limit: limit}
+}
+
+// Limit returns the bytes of the budget.
+func (b *Budget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the bytes received so far, 0 for a nil budget.
+func (b *Budget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
+
+// Exceeded returns whether more bytes than the budget have been received, always false for a nil budget.
+func (b *Budget) Exceeded() bool {
+	return b != nil && b.used.Load() > b.limit
+}
+
+// err returns the error of a request made or read after the budget is exceeded.
+func (b *Budget) err() error {
+	return fmt.Errorf("%w: over %d bytes", ErrBudgetExceeded, b.limit)
+}
+
+// budgetReader reads a response body and counts the bytes against a budget, failing once it is exceeded.
+type budgetReader struct {
+	io.ReadCloser
+	budget *Budget
+}
+
+func (r budgetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.budget.used.Add(int64(n)) > r.budget.limit {
+		return n, r.budget.err()
+	}
+	return n, err
+}
@@ -0,0 +1,71 @@
+package hound
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// ConnectResult is the result of connecting to an IP without sending any HTTP request.
+type ConnectResult struct {
+	Err  error
+	IP   probe.ResolvedIP
+	Port string
+	// Connect is the time taken to establish the TCP connection.
+	Connect time.Duration
+	// Handshake is the time taken by the TLS handshake, 0 if not performed.
+	Handshake time.Duration
+}
+
+// Connect connects to each of the given IPs on each of the given ports concurrently, and performs the TLS handshake
+// (with the ClientHello profile of opts) for serverName if not empty, then sends the results to ch.
+func Connect(ctx context.Context, ch chan<- ConnectResult, serverName string, ports []string, IPs []probe.ResolvedIP, opts Options) {
+	for _, IP := range IPs {
+		for _, port := range ports {
+			go func(IP probe.ResolvedIP, port string) {
+				ch <- connect(ctx, serverName, IP, port, opts)
+			}(IP, port)
+		}
+	}
+}
+
+// connect connects to the given IP on the given port, and performs the TLS handshake for serverName if not empty.
+func connect(ctx context.Context, serverName string, IP probe.ResolvedIP, port string, opts Options) ConnectResult {
+	r := ConnectResult{IP: IP, Port: port}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialerOf(opts).DialContext(ctx, "tcp", net.JoinHostPort(IP.String(), port))
+	if err != nil {
+		r.Err = fmt.Errorf("%w: %w", ErrConnect, err)
+		return r
+	}
+	defer conn.Close()
+	r.Connect = time.Since(start)
+	if serverName == "" {
+		return r
+	}
+
+	if opts.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.TLSHandshakeTimeout)
+		defer cancel()
+	}
+	start = time.Now()
+	if hello := helloOf(opts.TLSProfile); hello != nil {
+		_, err = handshake(ctx, conn, serverName, *hello, false)
+	} else {
+		err = tls.Client(conn, &tls.Config{ServerName: serverName}).HandshakeContext(ctx)
+	}
+	if err != nil {
+		r.Err = fmt.Errorf("%w: %w", ErrTLSHandshake, err)
+		return r
+	}
+	r.Handshake = time.Since(start)
+	return r
+}
@@ -0,0 +1,94 @@
+package hound
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cookieDomain = "sinaimg.cn"
+
+// NewCookieJar returns a cookie jar scoped to the Weibo image hosts,
+// loaded with the cookies from the Netscape cookie file at path (if not empty) and the given name-value pairs.
+// Cookies of other domains in the file are ignored.
+func NewCookieJar(path string, values map[string]string) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var cookies []hostCookie
+	if path != "" {
+		if cookies, err = readCookieFile(path); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range values {
+		cookies = append(cookies, hostCookie{
+			host:   cookieDomain,
+			cookie: &http.Cookie{Name: name, Value: value, Domain: cookieDomain, Path: "/"},
+		})
+	}
+	for _, c := range cookies {
+		jar.SetCookies(&url.URL{Scheme: "https", Host: c.host, Path: "/"}, []*http.Cookie{c.cookie})
+	}
+	return jar, nil
+}
+
+// hostCookie is a cookie along with the host it was set by.
+type hostCookie struct {
+	host   string
+	cookie *http.Cookie
+}
+
+// readCookieFile reads the cookies of the Weibo image hosts from the Netscape cookie file at path.
+func readCookieFile(path string) ([]hostCookie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cookie file: %w", err)
+	}
+	defer f.Close()
+
+	var cookies []hostCookie
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// domain, include subdomains, path, secure, expiry, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("invalid cookie file line %d", n)
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		if domain != cookieDomain && !strings.HasSuffix(domain, "."+cookieDomain) {
+			continue
+		}
+		c := &http.Cookie{
+			Domain: domain,
+			Path:   fields[2],
+			Secure: strings.EqualFold(fields[3], "TRUE"),
+			Name:   fields[5],
+			Value:  fields[6],
+		}
+		if !strings.EqualFold(fields[1], "TRUE") { // host-only cookie
+			c.Domain = ""
+		}
+		if expiry, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+		}
+		cookies = append(cookies, hostCookie{host: domain, cookie: c})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cookie file: %w", err)
+	}
+	return cookies, nil
+}
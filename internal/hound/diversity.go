@@ -0,0 +1,50 @@
+package hound
+
+// Diverse returns the given results reordered to spread across the groups given by the key functions,
+// e.g. network and region, so any prefix of them is as diverse as possible.
+// Each next result is the one whose groups are the least used so far, the earlier key functions taking precedence,
+// and the original order is kept among equally diverse ones.
+func Diverse(results []Result, keys ...func(Result) string) []Result {
+	used := make([]map[string]int, len(keys))
+	for i := range used {
+		used[i] = make(map[string]int)
+	}
+	groups := make([][]string, len(results))
+	for i, r := range results {
+		groups[i] = make([]string, len(keys))
+		for j, key := range keys {
+			groups[i][j] = key(r)
+		}
+	}
+
+	remaining := make([]int, len(results))
+	for i := range remaining {
+		remaining[i] = i
+	}
+	ordered := make([]Result, 0, len(results))
+	for len(remaining) > 0 {
+		best := 0
+		for k := 1; k < len(remaining); k++ {
+			if lessUsed(used, groups[remaining[k]], groups[remaining[best]]) {
+				best = k
+			}
+		}
+		i := remaining[best]
+		for j, g := range groups[i] {
+			used[j][g]++
+		}
+		ordered = append(ordered, results[i])
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+	return ordered
+}
+
+// lessUsed returns whether groups a are less used than groups b, compared key by key.
+func lessUsed(used []map[string]int, a []string, b []string) bool {
+	for j := range used {
+		if ua, ub := used[j][a[j]], used[j][b[j]]; ua != ub {
+			return ua < ub
+		}
+	}
+	return false
+}
@@ -1,46 +1,285 @@
 package hound
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/andybalholm/brotli"
+	"weibo-image-hound/internal/decode"
+	"weibo-image-hound/internal/probe"
+	"weibo-image-hound/internal/weibo"
 )
 
 type Result struct {
 	Err     error
 	Headers http.Header
-	IP      net.IP
+	URL     string
+	IP      probe.ResolvedIP
+	Port    string
 	Body    []byte
-	Status  int
+	// BodyReader is the unread body of a successful response in streaming mode (see Options.Stream),
+	// which must be closed by the receiver.
+	BodyReader io.ReadCloser
+	// Status is the HTTP status code, also set along with Err if the body of the response failed to be read.
+	Status   int
+	Duration time.Duration
+	// Certificates is the TLS certificate chain presented by the edge, leaf first, nil over plain HTTP.
+	Certificates []*x509.Certificate
+	// HTTP1Fallback is whether the request was retried over HTTP/1.1 after an HTTP/2 error (see Options.HTTP1Fallback).
+	HTTP1Fallback bool
+	// Redirects are the URLs redirected to in order, with Options.MaxRedirects.
+	Redirects []string
+	// Hit is whether the result counts as a hit (see Options.Hit), as decided by Hunt.
+	Hit bool
 }
 
-func Hunt(ctx context.Context, ch chan<- Result, URL string, port string, IPs []net.IP, headers http.Header) {
+// Options holds the optional settings of a hunt.
+type Options struct {
+	// Jar is the cookie jar to send cookies from, nil for no cookies.
+	Jar http.CookieJar
+	// Stream makes successful results carry their unread body in Result.BodyReader instead of Result.Body.
+	Stream bool
+	// StatusCodes are the HTTP status codes of successful results, defaults to only 200.
+	StatusCodes []int
+	// Method is the HTTP method of the requests, defaults to GET.
+	Method string
+	// HeaderTimeout is the timeout for receiving the response headers,
+	// defaults to no limit other than the overall timeout, or to the request timeout when MinRate is set.
+	HeaderTimeout time.Duration
+	// TLSProfile is the name of the TLS ClientHello profile to mimic (see TLSProfiles), defaults to the standard one.
+	TLSProfile string
+	// MinRate is the minimum average transfer rate (in bytes per second) of response bodies,
+	// when set, it replaces the overall timeout of requests so large images are not cut off.
+	MinRate int64
+	// HedgeDelay makes Hunt request from the IPs one after another instead of all at once,
+	// starting the next one when the in-flight ones haven't responded within the delay, or have failed.
+	HedgeDelay time.Duration
+	// RetryHandshake retries requests failed at the TLS handshake (see ErrTLSHandshake) once with a fresh connection.
+	RetryHandshake bool
+	// ConnectTimeout is the timeout of establishing the TCP connection, defaults to the overall timeout.
+	ConnectTimeout time.Duration
+	// TLSHandshakeTimeout is the timeout of the TLS handshake after connected, defaults to no limit other than the overall timeout.
+	TLSHandshakeTimeout time.Duration
+	// HTTP1Fallback retries requests failed by an HTTP/2 error (e.g. an immediate GOAWAY or a stream reset)
+	// once over HTTP/1.1 with the same IP.
+	HTTP1Fallback bool
+	// MaxBodySize is the maximum size in bytes of (decoded) response bodies read into memory,
+	// larger ones fail with ErrBodyTooLarge, defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+	// Budget limits the total bytes received by all requests sharing it, nil for no limit.
+	// Once exceeded, reading fails and no more requests are made, with ErrBudgetExceeded.
+	Budget *Budget
+	// MaxRedirects is the number of redirects followed, to the same IP, after which the last 30x response is the result.
+	// Defaults to not following redirects at all.
+	MaxRedirects int
+	// Spacer spaces out the requests to the same IP, shared across hunts, nil for no spacing.
+	Spacer *Spacer
+	// IsHit decides whether a result without error and with an accepted status code counts as a hit,
+	// e.g. by its size, content type or hash, defaults to Options.DefaultIsHit. It must be safe for concurrent use.
+	IsHit func(Result) bool
+	// PlaceholderHashes are the SHA-256 hashes (in hex) of placeholder bodies in addition to the built-in ones,
+	// which are not hits by default.
+	PlaceholderHashes []string
+}
+
+// ErrConnect is wrapped by the errors of requests failed at establishing the TCP connection,
+// e.g. by network filtering, as opposed to ErrTLSHandshake of TLS interference.
+var ErrConnect = errors.New("connect failed")
+
+// isConnectError returns whether the given request error happened at establishing the TCP connection.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// DefaultMaxBodySize is the default of Options.MaxBodySize, generous for any image.
+const DefaultMaxBodySize = 64 << 20
+
+// ErrBodyTooLarge is the error of results whose response body exceeds Options.MaxBodySize,
+// e.g. from a misbehaving edge or a decompression bomb.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// Accepts returns whether a result of the given HTTP status code is successful.
+func (o Options) Accepts(status int) bool {
+	if len(o.StatusCodes) == 0 {
+		return status == http.StatusOK
+	}
+	for _, s := range o.StatusCodes {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Hit returns whether the given result counts as a hit: without error, with an accepted status code
+// (see Options.Accepts), and by Options.IsHit.
+func (o Options) Hit(r Result) bool {
+	if r.Err != nil || !o.Accepts(r.Status) {
+		return false
+	}
+	if o.IsHit != nil {
+		return o.IsHit(r)
+	}
+	return o.DefaultIsHit(r)
+}
+
+// DefaultIsHit returns whether the body of the given result is not a known placeholder, if read into memory.
+func (o Options) DefaultIsHit(r Result) bool {
+	if r.BodyReader != nil || o.Method == http.MethodHead { // body unknown
+		return true
+	}
+	return !weibo.IsPlaceholder(r.Body, o.PlaceholderHashes)
+}
+
+// Hunt requests URL from each of the given IPs on each of the given ports concurrently,
+// and sends the results (len(IPs) * len(ports) in total) to ch.
+func Hunt(ctx context.Context, ch chan<- Result, URL string, ports []string, IPs []probe.ResolvedIP, headers http.Header, opts Options) {
+	if opts.HedgeDelay > 0 {
+		hedge(ctx, ch, URL, ports, IPs, headers, opts)
+		return
+	}
+	for _, IP := range IPs {
+		for _, port := range ports {
+			hunt(ctx, ch, URL, port, IP, headers, opts)
+		}
+	}
+}
+
+// hedge is like Hunt, but starts the requests one after another as they fail or don't respond within opts.HedgeDelay,
+// so a few slow IPs don't hold up the others, without requesting from all IPs at once.
+func hedge(ctx context.Context, ch chan<- Result, URL string, ports []string, IPs []probe.ResolvedIP, headers http.Header, opts Options) {
+	type attempt struct {
+		IP   probe.ResolvedIP
+		port string
+	}
+	attempts := make([]attempt, 0, len(IPs)*len(ports))
 	for _, IP := range IPs {
-		addr := fmt.Sprintf("%s:%s", IP, port)
-		if IP.To4() == nil { // IPv6 address
-			addr = fmt.Sprintf("[%s]:%s", IP, port)
+		for _, port := range ports {
+			attempts = append(attempts, attempt{IP: IP, port: port})
 		}
+	}
 
-		go func(IP net.IP) {
-			select {
-			case <-ctx.Done():
+	inner := make(chan Result, len(attempts))
+	var hedgeC <-chan time.Time
+	next, inFlight := 0, 0
+	for next < len(attempts) || inFlight > 0 {
+		if next < len(attempts) && (inFlight == 0 || hedgeC == nil) {
+			a := attempts[next]
+			next++
+			inFlight++
+			hunt(ctx, inner, URL, a.port, a.IP, headers, opts)
+			hedgeC = time.After(opts.HedgeDelay)
+		}
+		select {
+		case r := <-inner:
+			inFlight--
+			ch <- r
+			if !r.Hit { // failed, move on without waiting
+				hedgeC = nil
+			}
+		case <-hedgeC:
+			hedgeC = nil
+		case <-ctx.Done():
+			for ; inFlight > 0; inFlight-- { // the in-flight ones are failing by now, releasing their bodies
+				r := <-inner
+				if r.BodyReader != nil {
+					r.BodyReader.Close()
+				}
+				r.Body, r.BodyReader, r.Err = nil, nil, ctx.Err()
+				ch <- r
+			}
+			for _, a := range attempts[next:] {
+				ch <- Result{URL: URL, IP: a.IP, Port: a.port, Err: ctx.Err()}
+			}
+			return
+		}
+	}
+}
+
+// hunt requests URL from the given IP on the given port in a new goroutine, and sends the result to ch.
+func hunt(ctx context.Context, ch chan<- Result, URL string, port string, IP probe.ResolvedIP, headers http.Header, opts Options) {
+	addr := net.JoinHostPort(IP.String(), port)
+	send := func(r Result) {
+		r.Hit = opts.Hit(r)
+		if !r.Hit && r.BodyReader != nil {
+			r.BodyReader.Close()
+			r.BodyReader = nil
+		}
+		ch <- r
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			send(Result{URL: URL, IP: IP, Port: port, Err: ctx.Err()})
+		default:
+			if !opts.Spacer.wait(ctx, IP.IP) {
+				send(Result{URL: URL, IP: IP, Port: port, Err: ctx.Err()})
 				return
-			default:
-				status, respHeaders, body, err := newClient(ctx, addr).
-					request(http.MethodGet, URL, headers)
+			}
+			method := opts.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+			start := time.Now()
+			c := newClient(ctx, addr, opts)
+			if opts.Stream {
+				status, respHeaders, body, err := c.stream(method, URL, headers)
+				if err != nil && opts.RetryHandshake && errors.Is(err, ErrTLSHandshake) {
+					c = newClient(ctx, addr, opts)
+					status, respHeaders, body, err = c.stream(method, URL, headers)
+				}
+				fellBack := err != nil && opts.HTTP1Fallback && isHTTP2Error(err)
+				if fellBack {
+					c = newHTTP1Client(ctx, addr, opts)
+					status, respHeaders, body, err = c.stream(method, URL, headers)
+				}
 				if err != nil {
-					ch <- Result{IP: IP, Err: err}
+					send(Result{URL: URL, IP: IP, Port: port, Status: status, Headers: respHeaders, Err: err, HTTP1Fallback: fellBack})
 					return
 				}
-				ch <- Result{IP: IP, Status: status, Headers: respHeaders, Body: body}
+				send(Result{URL: URL, IP: IP, Port: port, Status: status, Headers: respHeaders, BodyReader: body, Duration: time.Since(start), Certificates: c.certs, Redirects: c.redirects, HTTP1Fallback: fellBack})
+				return
+			}
+			status, respHeaders, body, err := c.request(method, URL, headers)
+			if err != nil && opts.RetryHandshake && errors.Is(err, ErrTLSHandshake) {
+				c = newClient(ctx, addr, opts)
+				status, respHeaders, body, err = c.request(method, URL, headers)
+			}
+			if err != nil && errors.Is(err, decode.ErrBrotli) { // broken brotli of the edge, retry without it
+				c = newClient(ctx, addr, opts)
+				status, respHeaders, body, err = c.request(method, URL, withoutEncoding(headers))
+			}
+			fellBack := err != nil && opts.HTTP1Fallback && isHTTP2Error(err)
+			if fellBack {
+				c = newHTTP1Client(ctx, addr, opts)
+				status, respHeaders, body, err = c.request(method, URL, headers)
 			}
-		}(IP)
+			if err != nil {
+				send(Result{URL: URL, IP: IP, Port: port, Status: status, Headers: respHeaders, Err: err, Certificates: c.certs, Redirects: c.redirects, HTTP1Fallback: fellBack})
+				return
+			}
+			send(Result{URL: URL, IP: IP, Port: port, Status: status, Headers: respHeaders, Body: body, Duration: time.Since(start), Certificates: c.certs, Redirects: c.redirects, HTTP1Fallback: fellBack})
+		}
+	}()
+}
+
+// withoutEncoding returns a copy of the given request headers only accepting the identity content encoding.
+func withoutEncoding(headers http.Header) http.Header {
+	h := headers.Clone()
+	if h == nil {
+		h = make(http.Header)
 	}
+	h["Accept-Encoding"] = []string{"identity"}
+	return h
 }
 
 const (
@@ -50,7 +289,12 @@ const (
 
 type client struct {
 	*http.Client
-	ctx context.Context
+	ctx  context.Context
+	opts Options
+	// certs is the certificate chain presented by the server of the last response.
+	certs []*x509.Certificate
+	// redirects are the URLs redirected to in order.
+	redirects []string
 }
 
 var (
@@ -74,31 +318,114 @@ var (
 	}
 )
 
-func newClient(ctx context.Context, address string) *client {
-	return &client{
+// isHTTP2Error returns whether the given request error is an HTTP/2 connection or stream error, e.g. GOAWAY or RST_STREAM.
+func isHTTP2Error(err error) bool {
+	// the HTTP/2 errors of net/http are unexported
+	msg := err.Error()
+	return strings.Contains(msg, "http2:") || strings.Contains(msg, "stream error:") || strings.Contains(msg, "GOAWAY")
+}
+
+// newHTTP1Client is like newClient, but never negotiates HTTP/2.
+func newHTTP1Client(ctx context.Context, address string, opts Options) *client {
+	c := newClient(ctx, address, opts)
+	if t, ok := c.Transport.(*mimicTransport); ok {
+		t.http1 = true
+		return c
+	}
+	t := c.Transport.(*http.Transport)
+	t.ForceAttemptHTTP2 = false
+	t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper) // non-nil disables HTTP/2
+	return c
+}
+
+// dialerOf returns the dialer with the connect timeout of the given options.
+func dialerOf(opts Options) *net.Dialer {
+	if opts.ConnectTimeout <= 0 {
+		return dialer
+	}
+	d := *dialer
+	d.Timeout = opts.ConnectTimeout
+	return &d
+}
+
+func newClient(ctx context.Context, address string, opts Options) *client {
+	timeout, headerTimeout := clientTimeout, opts.HeaderTimeout
+	if opts.MinRate > 0 { // enforced by the header timeout and the transfer rate instead
+		timeout = 0
+		if headerTimeout == 0 {
+			headerTimeout = requestTimeout
+		}
+	}
+	d := dialerOf(opts)
+	t := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, network, address)
+		},
+		DisableKeepAlives:     true,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: headerTimeout,
+	}
+	var transport http.RoundTripper = t
+	if hello := helloOf(opts.TLSProfile); hello != nil {
+		transport = newMimicTransport(t, *hello)
+	}
+	c := &client{
 		Client: &http.Client{
-			Transport: &http.Transport{
-				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-					return dialer.DialContext(ctx, network, address)
-				},
-				DisableKeepAlives: true,
-				ForceAttemptHTTP2: true,
-			},
-			CheckRedirect: func(req *http.Request, via []*http.Request) error { // don't follow 301 redirect
-				return http.ErrUseLastResponse
-			},
-			Timeout: clientTimeout,
+			Transport: transport,
+			Jar:       opts.Jar,
+			Timeout:   timeout,
 		},
-		ctx: ctx,
+		ctx:  ctx,
+		opts: opts,
 	}
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > opts.MaxRedirects { // don't follow (any more), returning the 30x
+			return http.ErrUseLastResponse
+		}
+		c.redirects = append(c.redirects, req.URL.String())
+		return nil
+	}
+	return c
 }
 
 func (c *client) request(method string, URL string, reqHeaders http.Header) (statusCode int, respHeaders http.Header, body []byte, err error) {
-	ctx, cancel := context.WithTimeout(c.ctx, requestTimeout)
-	defer cancel()
+	statusCode, respHeaders, r, err := c.stream(method, URL, reqHeaders)
+	if err != nil {
+		return statusCode, respHeaders, nil, err
+	}
+	defer r.Close()
+
+	limit := c.opts.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	respBody, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return statusCode, respHeaders, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if int64(len(respBody)) > limit {
+		return statusCode, respHeaders, nil, fmt.Errorf("%w: over %d bytes", ErrBodyTooLarge, limit)
+	}
+	return statusCode, respHeaders, respBody, nil
+}
+
+// stream sends a request and returns the decoded response body without reading it, which must be closed by the caller.
+func (c *client) stream(method string, URL string, reqHeaders http.Header) (statusCode int, respHeaders http.Header, body io.ReadCloser, err error) {
+	if c.opts.Budget.Exceeded() {
+		return 0, nil, nil, c.opts.Budget.err()
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if c.opts.MinRate > 0 {
+		ctx, cancel = context.WithCancel(c.ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(c.ctx, requestTimeout)
+	}
 
 	req, err := http.NewRequestWithContext(ctx, method, URL, nil)
 	if err != nil {
+		cancel()
 		return 0, nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header = baseHeaders.Clone()
@@ -113,18 +440,56 @@ func (c *client) request(method string, URL string, reqHeaders http.Header) (sta
 
 	resp, err := c.Do(req)
 	if err != nil {
+		cancel()
+		if isConnectError(err) {
+			return 0, nil, nil, fmt.Errorf("%w: %w", ErrConnect, err)
+		}
+		if isHandshakeError(err) {
+			return 0, nil, nil, fmt.Errorf("%w: %w", ErrTLSHandshake, err)
+		}
 		return 0, nil, nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
+	if resp.TLS != nil {
+		c.certs = resp.TLS.PeerCertificates
+	}
+	if c.opts.Budget != nil {
+		resp.Body = budgetReader{ReadCloser: resp.Body, budget: c.opts.Budget}
+	}
 
-	var respBody []byte
-	if resp.Header.Get("content-encoding") == "br" {
-		respBody, err = io.ReadAll(brotli.NewReader(resp.Body))
-	} else {
-		respBody, err = io.ReadAll(resp.Body)
+	var r io.Reader = resp.Body
+	encoding := strings.Join(resp.Header.Values("content-encoding"), ",")
+	if br := bufio.NewReader(resp.Body); encoding != "" && method != http.MethodHead && !isEmpty(br) { // nothing to decode otherwise
+		if r, err = decode.Body(br, encoding); err != nil {
+			resp.Body.Close()
+			cancel()
+			return resp.StatusCode, resp.Header, nil, fmt.Errorf("failed to read response body: %w", err)
+		}
 	}
-	if err != nil {
-		return 0, nil, nil, fmt.Errorf("failed to read response body: %w", err)
+	if c.opts.MinRate > 0 {
+		rr := watchRate(r, c.opts.MinRate, cancel)
+		orig := cancel
+		r, cancel = rr, func() {
+			rr.stop()
+			orig()
+		}
 	}
-	return resp.StatusCode, resp.Header, respBody, nil
+	return resp.StatusCode, resp.Header, &bodyReader{Reader: r, body: resp.Body, cancel: cancel}, nil
+}
+
+// isEmpty returns whether the body read by br is empty, e.g. of a 204 or 304 response.
+func isEmpty(br *bufio.Reader) bool {
+	_, err := br.Peek(1)
+	return err == io.EOF
+}
+
+// bodyReader reads a (decoded) response body, and releases the request when closed.
+type bodyReader struct {
+	io.Reader
+	body   io.Closer
+	cancel context.CancelFunc
+}
+
+func (r *bodyReader) Close() error {
+	defer r.cancel()
+	return r.body.Close()
 }
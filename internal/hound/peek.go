@@ -0,0 +1,54 @@
+package hound
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// Peek requests only the first size bytes of the image at URL from all IPs with a "Range" header,
+// and returns the hit (see Options.Hit, also accepting HTTP 206) of the largest image dimensions (parsed from the image header) along with them.
+// Servers ignoring the "Range" header respond the full image (HTTP 200) in the result.
+func Peek(ctx context.Context, URL string, ports []string, IPs []probe.ResolvedIP, headers http.Header, opts Options, size int) (Result, image.Point, error) {
+	h := headers.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+	opts.Method = http.MethodGet
+	opts.Stream = false
+	opts.StatusCodes = append([]int{http.StatusOK, http.StatusPartialContent}, opts.StatusCodes...) // the full image if Range is ignored
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	n := len(IPs) * len(ports)
+	ch := make(chan Result, n)
+	go Hunt(ctx, ch, URL, ports, IPs, h, opts)
+
+	var best Result
+	var dims image.Point
+	for i := 0; i < n; i++ {
+		r := <-ch
+		if !r.Hit || (r.Status != http.StatusPartialContent && r.Status != http.StatusOK) {
+			continue
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(r.Body))
+		if err != nil {
+			continue
+		}
+		if best.IP.IP == nil || cfg.Width*cfg.Height > dims.X*dims.Y {
+			best, dims = r, image.Pt(cfg.Width, cfg.Height)
+		}
+	}
+	if best.IP.IP == nil {
+		return Result{}, image.Point{}, fmt.Errorf("no valid image header from any IP")
+	}
+	return best, dims, nil
+}
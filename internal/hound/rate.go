@@ -0,0 +1,69 @@
+package hound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rateCheckInterval = 500 * time.Millisecond
+	rateGracePeriod   = 2 * time.Second // before the transfer rate is checked, for the TCP slow start
+)
+
+// errTooSlow is returned when reading a response body slower than the minimum transfer rate.
+var errTooSlow = errors.New("transfer rate below the minimum")
+
+// rateReader reads a response body and aborts the request (by cancelling its context)
+// when the average transfer rate drops below a minimum.
+type rateReader struct {
+	r        io.Reader
+	n        atomic.Int64
+	slow     atomic.Bool
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// watchRate returns a reader of r which calls cancel when the average transfer rate is below minRate (in bytes per second).
+func watchRate(r io.Reader, minRate int64, cancel context.CancelFunc) *rateReader {
+	rr := &rateReader{r: r, done: make(chan struct{})}
+	go func() {
+		start := time.Now()
+		ticker := time.NewTicker(rateCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-rr.done:
+				return
+			case <-ticker.C:
+				elapsed := time.Since(start)
+				if elapsed < rateGracePeriod {
+					continue
+				}
+				if float64(rr.n.Load()) < elapsed.Seconds()*float64(minRate) {
+					rr.slow.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return rr
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	if err != nil && err != io.EOF && r.slow.Load() {
+		err = errTooSlow
+	}
+	return n, err
+}
+
+// stop stops watching the transfer rate.
+func (r *rateReader) stop() {
+	r.stopOnce.Do(func() { close(r.done) })
+}
@@ -0,0 +1,76 @@
+package hound
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Selector selects the winning result among the successful results of a hunt.
+type Selector interface {
+	// Offer offers a successful result to the selector, and returns whether the hunt can stop now.
+	Offer(r Result) (done bool)
+	// Selected returns the selected result, and whether any result was selected.
+	Selected() (Result, bool)
+}
+
+// Strategies lists the names of all built-in selection strategies.
+var Strategies = []string{"first", "fastest", "largest", "fresh"}
+
+// NewSelector returns a new selector of the built-in strategy with the given name.
+func NewSelector(strategy string) (Selector, error) {
+	switch strategy {
+	case "first", "":
+		return &firstSelector{}, nil
+	case "fastest":
+		return &bestSelector{better: func(a, b Result) bool { return a.Duration < b.Duration }}, nil
+	case "largest":
+		return &bestSelector{better: func(a, b Result) bool { return len(a.Body) > len(b.Body) }}, nil
+	case "fresh":
+		return &bestSelector{better: func(a, b Result) bool { return age(a) < age(b) }}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy: %s", strategy)
+	}
+}
+
+// firstSelector selects the first successful result.
+type firstSelector struct {
+	result Result
+	ok     bool
+}
+
+func (s *firstSelector) Offer(r Result) bool {
+	s.result, s.ok = r, true
+	return true
+}
+
+func (s *firstSelector) Selected() (Result, bool) {
+	return s.result, s.ok
+}
+
+// bestSelector waits for all results, and selects the best one according to the better function.
+type bestSelector struct {
+	better func(a, b Result) bool
+	result Result
+	ok     bool
+}
+
+func (s *bestSelector) Offer(r Result) bool {
+	if !s.ok || s.better(r, s.result) {
+		s.result, s.ok = r, true
+	}
+	return false
+}
+
+func (s *bestSelector) Selected() (Result, bool) {
+	return s.result, s.ok
+}
+
+// age returns the age in seconds of the cached response of a result, from its "Age" header.
+// Responses without the header are considered fresh from the origin.
+func age(r Result) int64 {
+	a, err := strconv.ParseInt(r.Headers.Get("age"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return a
+}
@@ -0,0 +1,69 @@
+package hound
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"weibo-image-hound/internal/probe"
+)
+
+func TestSelector(t *testing.T) {
+	results := []Result{
+		{IP: probe.ResolvedIP{IP: net.IPv4(127, 0, 0, 1)}, Duration: 300 * time.Millisecond, Body: make([]byte, 10), Headers: http.Header{"Age": {"600"}}},
+		{IP: probe.ResolvedIP{IP: net.IPv4(127, 0, 0, 2)}, Duration: 100 * time.Millisecond, Body: make([]byte, 30), Headers: http.Header{"Age": {"60"}}},
+		{IP: probe.ResolvedIP{IP: net.IPv4(127, 0, 0, 3)}, Duration: 200 * time.Millisecond, Body: make([]byte, 20), Headers: http.Header{}},             // fresh from the origin
+		{IP: probe.ResolvedIP{IP: net.IPv4(127, 0, 0, 4)}, Duration: 100 * time.Millisecond, Body: make([]byte, 30), Headers: http.Header{"Age": {"x"}}}, // ties with the earlier ones, kept
+	}
+	tests := []struct {
+		name     string
+		strategy string
+		wantIP   string
+		wantDone bool // after the first offer
+	}{
+		{name: "default", strategy: "", wantIP: "127.0.0.1", wantDone: true},
+		{name: "first", strategy: "first", wantIP: "127.0.0.1", wantDone: true},
+		{name: "fastest", strategy: "fastest", wantIP: "127.0.0.2"},
+		{name: "largest", strategy: "largest", wantIP: "127.0.0.2"},
+		{name: "fresh", strategy: "fresh", wantIP: "127.0.0.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSelector(tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := s.Selected(); ok {
+				t.Fatal("selected before any offer")
+			}
+			for i, r := range results {
+				done := s.Offer(r)
+				if i == 0 && done != tt.wantDone {
+					t.Errorf("got done %v after the first offer, want %v", done, tt.wantDone)
+				}
+				if done {
+					break
+				}
+			}
+			r, ok := s.Selected()
+			if !ok {
+				t.Fatal("nothing selected")
+			}
+			if r.IP.String() != tt.wantIP {
+				t.Errorf("selected %s, want %s", r.IP, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestSelectorStrategies(t *testing.T) {
+	for _, strategy := range Strategies {
+		if _, err := NewSelector(strategy); err != nil {
+			t.Errorf("strategy %s: %v", strategy, err)
+		}
+	}
+	if _, err := NewSelector("slowest"); err == nil {
+		t.Error("want error for an unknown strategy, got nil")
+	}
+}
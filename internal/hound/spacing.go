@@ -0,0 +1,48 @@
+package hound
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Spacer spaces out the requests to the same IP (on any port) by a minimum interval,
+// so edges rate-limiting per source are not hit in rapid succession, e.g. once per quality. It is safe for concurrent use.
+type Spacer struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     map[string]time.Time // by IP, when the next request may be made
+}
+
+// NewSpacer returns a spacer of the given minimum interval.
+func NewSpacer(interval time.Duration) *Spacer {
+	return &Spacer{interval: interval, next: make(map[string]time.Time)}
+}
+
+// wait blocks until a request to IP may be made and reserves it, and returns false if ctx is done before that.
+// It returns immediately for a nil spacer.
+func (s *Spacer) wait(ctx context.Context, IP net.IP) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	now := time.Now()
+	at := s.next[IP.String()]
+	if at.Before(now) {
+		at = now
+	}
+	s.next[IP.String()] = at.Add(s.interval)
+	s.mu.Unlock()
+
+	if d := time.Until(at); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package hound
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+)
+
+// ErrTLSHandshake is wrapped by the errors of requests failed at the TLS handshake, which are often transient.
+var ErrTLSHandshake = errors.New("TLS handshake failed")
+
+// isHandshakeError returns whether the given request error happened at the TLS handshake.
+func isHandshakeError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) {
+		return true
+	}
+	var uRecordErr utls.RecordHeaderError // of the mimicked profiles
+	var uAlertErr utls.AlertError
+	if errors.As(err, &uRecordErr) || errors.As(err, &uAlertErr) {
+		return true
+	}
+	// the handshake timeout error of net/http is unexported
+	return strings.Contains(err.Error(), "TLS handshake")
+}
+
+// TLSProfiles lists the names of the TLS ClientHello profiles, "go" being the standard one.
+var TLSProfiles = []string{"go", "chrome"}
+
+// helloOf returns the ClientHello of the given profile parroted by utls, nil for the standard one of crypto/tls.
+func helloOf(profile string) *utls.ClientHelloID {
+	switch profile {
+	case "chrome": // matching baseHeaders
+		return &utls.HelloChrome_Auto
+	default:
+		return nil
+	}
+}
+
+// handshake performs the TLS handshake for serverName over conn with the given ClientHello,
+// offering only HTTP/1.1 by ALPN if http1 is set.
+func handshake(ctx context.Context, conn net.Conn, serverName string, hello utls.ClientHelloID, http1 bool) (*utls.UConn, error) {
+	spec, err := utls.UTLSIdToSpec(hello)
+	if err != nil {
+		return nil, err
+	}
+	if http1 {
+		for _, e := range spec.Extensions {
+			if alpn, ok := e.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = []string{"http/1.1"}
+			}
+		}
+	}
+	uconn := utls.UClient(conn, &utls.Config{ServerName: serverName}, utls.HelloCustom)
+	if err = uconn.ApplyPreset(&spec); err != nil {
+		return nil, err
+	}
+	if err = uconn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return uconn, nil
+}
+
+// mimicTransport is the round tripper of the ClientHello profiles parroted by utls (see helloOf),
+// as http.Transport only speaks HTTP/2 over crypto/tls connections.
+// It makes a new connection for each request, speaking HTTP/2 or HTTP/1.1 as negotiated.
+type mimicTransport struct {
+	t1    *http.Transport  // dialing the TCP connections, speaking HTTP/1.1 and plain HTTP
+	t2    *http2.Transport // configured from t1, e.g. its response header timeout
+	hello utls.ClientHelloID
+	http1 bool // only offer HTTP/1.1
+}
+
+// newMimicTransport returns a new mimicTransport of the given ClientHello over the connections dialed by t1.
+func newMimicTransport(t1 *http.Transport, hello utls.ClientHelloID) *mimicTransport {
+	t2, _ := http2.ConfigureTransports(t1) // only fails if already configured
+	return &mimicTransport{t1: t1, t2: t2, hello: hello}
+}
+
+func (t *mimicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return t.t1.RoundTrip(req)
+	}
+	conn, err := t.t1.DialContext(req.Context(), "tcp", req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	ctx := req.Context()
+	if t.t1.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.t1.TLSHandshakeTimeout)
+		defer cancel()
+	}
+	uconn, err := handshake(ctx, conn, req.URL.Hostname(), t.hello, t.http1)
+	if err != nil {
+		conn.Close()
+		if req.Context().Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, errors.New("TLS handshake timeout") // like net/http
+		}
+		return nil, err
+	}
+
+	state := uconn.ConnectionState()
+	var resp *http.Response
+	if state.NegotiatedProtocol == http2.NextProtoTLS {
+		cc, err := t.t2.NewClientConn(uconn) // single use, closed once the response is read
+		if err != nil {
+			uconn.Close()
+			return nil, err
+		}
+		if resp, err = cc.RoundTrip(req); err != nil {
+			cc.Close()
+			return nil, err
+		}
+	} else {
+		t1 := t.t1.Clone()
+		t1.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper) // non-nil disables HTTP/2
+		t1.DialTLSContext = func(context.Context, string, string) (net.Conn, error) { return uconn, nil }
+		if resp, err = t1.RoundTrip(req); err != nil {
+			uconn.Close()
+			return nil, err
+		}
+	}
+	resp.TLS = &tls.ConnectionState{ // only set by net/http for crypto/tls connections
+		Version:            state.Version,
+		HandshakeComplete:  state.HandshakeComplete,
+		CipherSuite:        state.CipherSuite,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ServerName:         state.ServerName,
+		PeerCertificates:   state.PeerCertificates,
+		VerifiedChains:     state.VerifiedChains,
+	}
+	return resp, nil
+}
@@ -0,0 +1,121 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"os"
+	"time"
+)
+
+// ErrUnsupported is returned by Embed when the image format doesn't support embedded metadata.
+var ErrUnsupported = errors.New("unsupported image format")
+
+// Metadata represents the provenance of a recovered image.
+type Metadata struct {
+	SourceURL string    `json:"source_url"`
+	IP        string    `json:"ip"`
+	Date      time.Time `json:"date"`
+	Tool      string    `json:"tool"`
+}
+
+var (
+	jpegSOI      = []byte{0xff, 0xd8}
+	jpegXMPNS    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// Embed returns a copy of the given encoded image with the metadata embedded,
+// as an XMP packet for JPEG, or text chunks for PNG.
+// It returns ErrUnsupported for other formats.
+func Embed(data []byte, m Metadata) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		return embedJPEG(data, m)
+	case bytes.HasPrefix(data, pngSignature):
+		return embedPNG(data, m)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// WriteSidecar writes the metadata as JSON to the sidecar file of the image at path, with the given mode.
+func WriteSidecar(path string, m Metadata, mode os.FileMode) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if err = os.WriteFile(path+".json", append(b, '\n'), mode); err != nil {
+		return fmt.Errorf("failed to write sidecar file: %w", err)
+	}
+	// os.WriteFile applies the umask, and keeps the mode of an existing file
+	if err = os.Chmod(path+".json", mode); err != nil {
+		return fmt.Errorf("failed to set sidecar file mode: %w", err)
+	}
+	return nil
+}
+
+// embedJPEG inserts an APP1 XMP segment right after the SOI marker (and the JFIF APP0 segment, if any).
+func embedJPEG(data []byte, m Metadata) ([]byte, error) {
+	packet := fmt.Sprintf(`<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>`+
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`+
+		`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:wih="https://github.com/zry98/weibo-image-hound/ns/1.0/">`+
+		`<dc:source>%s</dc:source><xmp:CreatorTool>%s</xmp:CreatorTool><xmp:MetadataDate>%s</xmp:MetadataDate><wih:IP>%s</wih:IP>`+
+		`</rdf:Description></rdf:RDF></x:xmpmeta><?xpacket end="w"?>`,
+		html.EscapeString(m.SourceURL), html.EscapeString(m.Tool), m.Date.Format(time.RFC3339), html.EscapeString(m.IP))
+	length := 2 + len(jpegXMPNS) + len(packet)
+	if length > 0xffff {
+		return nil, fmt.Errorf("metadata too large")
+	}
+
+	pos := len(jpegSOI)
+	if len(data) >= pos+4 && data[pos] == 0xff && data[pos+1] == 0xe0 { // skip JFIF APP0
+		pos += 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if pos > len(data) {
+			return nil, fmt.Errorf("invalid JPEG")
+		}
+	}
+	var b bytes.Buffer
+	b.Grow(len(data) + length + 2)
+	b.Write(data[:pos])
+	b.Write([]byte{0xff, 0xe1, byte(length >> 8), byte(length)})
+	b.Write(jpegXMPNS)
+	b.WriteString(packet)
+	b.Write(data[pos:])
+	return b.Bytes(), nil
+}
+
+// embedPNG inserts tEXt chunks right after the IHDR chunk.
+func embedPNG(data []byte, m Metadata) ([]byte, error) {
+	pos := len(pngSignature) + 4 + 4 + 13 + 4 // length, type, data, CRC of IHDR
+	if len(data) < pos || string(data[len(pngSignature)+4:len(pngSignature)+8]) != "IHDR" {
+		return nil, fmt.Errorf("invalid PNG")
+	}
+	var b bytes.Buffer
+	b.Write(data[:pos])
+	for _, kv := range [][2]string{
+		{"Source", m.SourceURL},
+		{"Software", m.Tool},
+		{"Creation Time", m.Date.Format(time.RFC1123Z)},
+		{"Source IP", m.IP},
+	} {
+		writePNGChunk(&b, "tEXt", append(append([]byte(kv[0]), 0), kv[1]...))
+	}
+	b.Write(data[pos:])
+	return b.Bytes(), nil
+}
+
+// writePNGChunk writes a PNG chunk of the given type and data to b.
+func writePNGChunk(b *bytes.Buffer, typ string, data []byte) {
+	_ = binary.Write(b, binary.BigEndian, uint32(len(data)))
+	b.WriteString(typ)
+	b.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	_ = binary.Write(b, binary.BigEndian, crc.Sum32())
+}
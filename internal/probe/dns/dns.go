@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"weibo-image-hound/internal/probe"
+)
+
+const defaultTimeout = 5 * time.Second
+
+type Config struct {
+	// Resolvers are the addresses (host:port, port defaults to 53) of the DNS servers to query, each as a location.
+	Resolvers []string `yaml:"resolvers,omitempty,flow"`
+	// Timeout is the timeout of each lookup, defaults to 5 seconds.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// MaxRetries is the number of times a failed lookup is retried.
+	MaxRetries int `yaml:"max_retries,omitempty"`
+}
+
+// Provider is a provider querying DNS servers directly over UDP, falling back to TCP for truncated answers.
+type Provider struct {
+	resolvers  []string
+	timeout    time.Duration
+	maxRetries int
+}
+
+// New returns a DNS provider querying the given resolvers.
+func New(cfg Config) *Provider {
+	resolvers := make([]string, 0, len(cfg.Resolvers))
+	for _, r := range cfg.Resolvers {
+		if _, _, err := net.SplitHostPort(r); err != nil {
+			r = net.JoinHostPort(r, "53")
+		}
+		resolvers = append(resolvers, r)
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Provider{resolvers: resolvers, timeout: timeout, maxRetries: cfg.MaxRetries}
+}
+
+// Resolve returns the A and AAAA answers of the given hostname from all the given resolvers (locations).
+func (p *Provider) Resolve(hostname string, locations []string) ([]probe.ResolvedIP, error) {
+	if len(locations) == 0 {
+		locations = p.resolvers
+	}
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("no resolvers specified")
+	}
+
+	var (
+		IPs  []net.IP
+		errs []error
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+	)
+	for _, r := range locations {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			r, err := p.lookup(address, hostname)
+			for i := 0; err != nil && i < p.maxRetries; i++ {
+				r, err = p.lookup(address, hostname)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", address, err))
+				return
+			}
+			IPs = append(IPs, r...)
+		}(r)
+	}
+	wg.Wait()
+	if len(IPs) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return probe.FromIPs(IPs), nil
+}
+
+// lookup queries the resolver at the given address for the A and AAAA records of the given hostname.
+func (p *Provider) lookup(address string, hostname string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	resolver := &net.Resolver{
+		PreferGo: true, // the cgo resolver can't be pointed at a server
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address) // network is "tcp" when retrying a truncated answer
+		},
+	}
+	return resolver.LookupIP(ctx, "ip", hostname)
+}
+
+// Locations returns the configured resolvers.
+func (p *Provider) Locations() ([]string, error) {
+	if len(p.resolvers) == 0 {
+		return nil, fmt.Errorf("no resolvers configured in \"providers.dns.resolvers\"")
+	}
+	return p.resolvers, nil
+}
@@ -0,0 +1,56 @@
+package fallback
+
+import (
+	"errors"
+	"fmt"
+
+	"weibo-image-hound/internal/probe"
+)
+
+// Provider is a provider trying each of the wrapped providers in order, until one resolves any IP.
+type Provider struct {
+	providers []probe.Provider
+	names     []string
+}
+
+// New returns a fallback provider of the given providers, with their names for error messages.
+func New(names []string, providers []probe.Provider) *Provider {
+	return &Provider{providers: providers, names: names}
+}
+
+// Resolve returns the resolved IP addresses of the first provider resolving any.
+// The given locations are used for the first provider, and the others use their own.
+func (p *Provider) Resolve(hostname string, locations []string) ([]probe.ResolvedIP, error) {
+	var errs []error
+	for i, provider := range p.providers {
+		locs := locations
+		if i > 0 {
+			var err error
+			if locs, err = provider.Locations(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: failed to get locations: %w", p.names[i], err))
+				continue
+			}
+		}
+		resolved, err := provider.Resolve(hostname, locs)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.names[i], err))
+			continue
+		}
+		if len(resolved) > 0 {
+			return resolved, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: no IPs resolved", p.names[i]))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no providers")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Locations returns the locations of the first provider.
+func (p *Provider) Locations() ([]string, error) {
+	if len(p.providers) == 0 {
+		return nil, fmt.Errorf("no providers")
+	}
+	return p.providers[0].Locations()
+}
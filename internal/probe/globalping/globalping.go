@@ -4,23 +4,37 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/andybalholm/brotli"
+	"weibo-image-hound/internal/decode"
 )
 
 const (
-	baseURL                      = "https://api.globalping.io/v1"
-	requestTimeout               = 15 * time.Second
-	getMeasurementInterval       = 5 * time.Second
-	getMeasurementOverallTimeout = 1 * time.Minute
+	baseURL                   = "https://api.globalping.io/v1"
+	probesPerLocation         = 5
+	defaultRequestTimeout     = 15 * time.Second
+	defaultMeasurementTimeout = 1 * time.Minute
+	defaultPollInterval       = 2 * time.Second // between the polls of all in-progress measurements
+	retryBackoff              = 1 * time.Second // multiplied by the number of the retry
+
+	defaultMaxIdleConns        = 10
+	defaultMaxIdleConnsPerHost = 4
+	defaultMaxConnsPerHost     = 8
+	defaultIdleConnTimeout     = 30 * time.Second
+
+	defaultDialTimeout           = 5 * time.Second
+	defaultTLSHandshakeTimeout   = 5 * time.Second
+	defaultResponseHeaderTimeout = 10 * time.Second
 )
 
 var (
@@ -38,13 +52,28 @@ var (
 // client represents a client for the GlobalPing API.
 type client struct {
 	*http.Client
-	eTags map[string]string
-	mu    sync.Mutex
+	eTags      map[string]string
+	rawDump    io.Writer
+	dialer     *net.Dialer
+	apiAddress string
+	targets    map[string]Target
+	tracker    Tracker
+	poller     *poller
+	mu         sync.Mutex
+
+	requestTimeout     time.Duration
+	measurementTimeout time.Duration
+	maxRetries         int
+	tag                string
+	createLimiter      *limiter // nil for no limit
 }
 
-// createMeasurement creates a new measurement and returns its ID.
+// errUnavailable is the error of API responses of server errors, which are worth retrying.
+var errUnavailable = errors.New("API unavailable")
+
+// createMeasurement creates a new measurement of the given type and returns its ID.
 // API `POST /v1/measurements`, documentation at https://www.jsdelivr.com/docs/api.globalping.io#post-/v1/measurements
-func (c *client) createMeasurement(hostname string, regions []string) (string, error) {
+func (c *client) createMeasurement(mType measurementType, hostname string, regions []string) (string, error) {
 	if hostname == "" {
 		return "", fmt.Errorf("no hostname specified")
 	}
@@ -55,19 +84,23 @@ func (c *client) createMeasurement(hostname string, regions []string) (string, e
 	for _, r := range regions {
 		mLocations = append(mLocations, location{
 			Region: r,
-			Limit:  5,
+			Limit:  probesPerLocation,
 		})
 	}
 
-	reqBody, err := json.Marshal(measurementRequest{
-		Type:      measurementTypePing,
+	reqBody, err := json.Marshal(&measurementRequest{
+		Type:      mType,
 		Target:    hostname,
 		Locations: mLocations,
+		Tag:       c.tag,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
+	if c.createLimiter != nil {
+		c.createLimiter.wait()
+	}
 	URL := baseURL + "/measurements"
 	body, err := c.request(http.MethodPost, URL, bytes.NewBuffer(reqBody), nil)
 	if err != nil {
@@ -84,72 +117,114 @@ func (c *client) createMeasurement(hostname string, regions []string) (string, e
 	if r.ID == "" {
 		return "", fmt.Errorf("invalid response: %s", string(body))
 	}
+	if c.tracker != nil {
+		c.tracker.Created(r.ID, hostname)
+	}
 	return r.ID, nil
 }
 
-// getMeasurement returns the results of the measurement with the given ID.
+// getMeasurement returns the results of the measurement with the given ID, polling it with the other in-progress ones.
 // API `GET /v1/measurements/{id}`, documentation at https://www.jsdelivr.com/docs/api.globalping.io#get-/v1/measurements/-id-
 func (c *client) getMeasurement(ID string) ([]measurementResult, error) {
 	if ID == "" {
 		return nil, fmt.Errorf("no measurement ID specified")
 	}
-	URL := baseURL + "/measurements/" + ID
 	defer func() {
 		c.mu.Lock()
-		delete(c.eTags, URL)
+		delete(c.eTags, baseURL+"/measurements/"+ID)
 		c.mu.Unlock()
 	}()
 
-	ticker := time.NewTicker(getMeasurementInterval)
-	defer ticker.Stop()
-	overallTimeout := time.NewTimer(getMeasurementOverallTimeout)
-	defer overallTimeout.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			body, err := c.request(http.MethodGet, URL, nil, nil)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to get measurement: %v\n", err)
-				continue
-			}
-			if body == nil { // HTTP 304 Not Modified
-				fmt.Fprintf(os.Stderr, "Measurement %s in progress...\n", ID)
-				continue
-			}
+	return c.poller.wait(ID, c.measurementTimeout)
+}
 
-			var r responseOnSuccess
-			if err = json.Unmarshal(body, &r); err != nil {
-				return nil, fmt.Errorf("failed to unmarshal response body: %w", err)
-			}
-			if r.ID == "" {
-				return nil, fmt.Errorf("invalid response: %s", string(body))
-			}
-			switch r.Status {
-			case "in-progress":
-				fmt.Fprintf(os.Stderr, "Measurement %s in progress...\n", r.ID)
-				continue
-			case "finished":
-				fmt.Fprintf(os.Stderr, "Measurement %s finished with %d results.\n", r.ID, len(r.Results))
-				return r.Results, nil
-			default:
-				return nil, fmt.Errorf("invalid response: unknown status \"%s\"", r.Status)
-			}
-		case <-overallTimeout.C:
-			return nil, fmt.Errorf("timeout")
+// pollMeasurement requests the measurement with the given ID once,
+// and returns its results and true if finished, or false if still in progress.
+func (c *client) pollMeasurement(ID string) ([]measurementResult, bool, error) {
+	URL := baseURL + "/measurements/" + ID
+	body, err := c.request(http.MethodGet, URL, nil, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get measurement: %v\n", err)
+		return nil, false, nil
+	}
+	if body == nil { // HTTP 304 Not Modified
+		fmt.Fprintf(os.Stderr, "Measurement %s in progress...\n", ID)
+		return nil, false, nil
+	}
+
+	var r responseOnSuccess
+	if err = json.Unmarshal(body, &r); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal response body: %w", err)
+	}
+	if r.ID == "" {
+		return nil, false, fmt.Errorf("invalid response: %s", string(body))
+	}
+	switch r.Status {
+	case "in-progress":
+		fmt.Fprintf(os.Stderr, "Measurement %s in progress...\n", r.ID)
+		return nil, false, nil
+	case "finished":
+		fmt.Fprintf(os.Stderr, "Measurement %s finished with %d results.\n", r.ID, len(r.Results))
+		c.dumpRaw(body)
+		if c.tracker != nil {
+			c.tracker.Finished(r.ID)
+		}
+		return r.Results, true, nil
+	case "failed":
+		c.dumpRaw(body)
+		if c.tracker != nil { // nothing to resume
+			c.tracker.Finished(r.ID)
 		}
+		return nil, false, fmt.Errorf("measurement %s failed: %s", r.ID, failureOf(r.Results))
+	default:
+		return nil, false, fmt.Errorf("invalid response: unknown status \"%s\"", r.Status)
+	}
+}
+
+// failureOf returns why the measurement of the given results failed, from the distinct errors of its failed results.
+func failureOf(results []measurementResult) string {
+	var reasons []string
+	for _, r := range results {
+		if r.Result.Status != "failed" {
+			continue
+		}
+		reason := strings.TrimSpace(r.Result.RawOutput)
+		if reason == "" {
+			reason = "unknown error"
+		}
+		reason = fmt.Sprintf("%s (from %s)", reason, r.Probe.Location.Region)
+		if !slices.Contains(reasons, reason) {
+			reasons = append(reasons, reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return "no reason given"
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// dumpRaw writes the given raw response body to the raw dump writer, if any.
+func (c *client) dumpRaw(body []byte) {
+	if c.rawDump == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.rawDump.Write(append(body, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to dump raw measurement: %v\n", err)
 	}
 }
 
 // getProbes returns a list of all currently connected probes.
 // API `GET /v1/probes`, documentation at https://www.jsdelivr.com/docs/api.globalping.io#get-/v1/probes
-func (c *client) getProbes() ([]probe, error) {
+func (c *client) getProbes() ([]probeInfo, error) {
 	URL := baseURL + "/probes"
 	body, err := c.request(http.MethodGet, URL, nil, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var probes []probe
+	var probes []probeInfo
 	if err = json.Unmarshal(body, &probes); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response body: %w", err)
 	}
@@ -157,8 +232,30 @@ func (c *client) getProbes() ([]probe, error) {
 }
 
 // request sends a request to the API and returns the response body.
+// GET requests failed by a network error or a server error are retried up to the client's maximum retries,
+// and ones failed by corrupt brotli data are retried without brotli.
 func (c *client) request(method string, URL string, reqBody io.Reader, reqHeaders http.Header) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	body, err := c.send(method, URL, reqBody, reqHeaders)
+	var netErr net.Error
+	for i := 1; i <= c.maxRetries && err != nil && method == http.MethodGet &&
+		(errors.As(err, &netErr) || errors.Is(err, errUnavailable)); i++ {
+		time.Sleep(time.Duration(i) * retryBackoff)
+		body, err = c.send(method, URL, reqBody, reqHeaders)
+	}
+	if err != nil && method == http.MethodGet && errors.Is(err, decode.ErrBrotli) {
+		h := reqHeaders.Clone()
+		if h == nil {
+			h = make(http.Header)
+		}
+		h["accept-encoding"] = []string{"identity"}
+		body, err = c.send(method, URL, reqBody, h)
+	}
+	return body, err
+}
+
+// send sends a request to the API and returns the response body.
+func (c *client) send(method string, URL string, reqBody io.Reader, reqHeaders http.Header) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, method, URL, reqBody)
@@ -189,12 +286,11 @@ func (c *client) request(method string, URL string, reqBody io.Reader, reqHeader
 	}
 	defer resp.Body.Close()
 
-	var body []byte
-	if resp.Header.Get("content-encoding") == "br" {
-		body, err = io.ReadAll(brotli.NewReader(resp.Body))
-	} else {
-		body, err = io.ReadAll(resp.Body)
+	r, err := decode.Body(resp.Body, strings.Join(resp.Header.Values("content-encoding"), ","))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
+	body, err := io.ReadAll(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
@@ -230,5 +326,8 @@ func (c *client) request(method string, URL string, reqBody io.Reader, reqHeader
 		}
 		return nil, fmt.Errorf("too many requests")
 	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("%w (HTTP %d)", errUnavailable, resp.StatusCode)
+	}
 	return body, fmt.Errorf("unexpected response (HTTP %d)", resp.StatusCode)
 }
@@ -0,0 +1,32 @@
+package globalping
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket of one token, evenly spacing the measurement creations to a maximum rate,
+// safe for concurrent use.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration // between the tokens
+	next     time.Time     // when the next token is available
+}
+
+// newLimiter returns a limiter of the given number of tokens per minute.
+func newLimiter(perMinute int) *limiter {
+	return &limiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// wait blocks until a token is available, and takes it.
+func (l *limiter) wait() {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
@@ -9,10 +9,12 @@ import (
 type measurementRequest struct {
 	pingOptions *pingOptions
 	httpOptions *httpOptions
+	dnsOptions  *dnsOptions
 	Type        measurementType `json:"type"`
 	Target      string          `json:"target"`
 	Options     interface{}     `json:"measurementOptions,omitempty"`
 	Locations   []location      `json:"locations"`
+	Tag         string          `json:"tag,omitempty"`
 }
 
 func (r *measurementRequest) MarshalJSON() ([]byte, error) {
@@ -40,6 +42,14 @@ func (r *measurementRequest) MarshalJSON() ([]byte, error) {
 			r.httpOptions = &httpOptions{}
 		}
 		a.Options = r.httpOptions
+	case measurementTypeDNS:
+		if r.dnsOptions == nil {
+			r.dnsOptions = &dnsOptions{}
+		}
+		if r.dnsOptions.Query.Type == "" {
+			r.dnsOptions.Query.Type = "A"
+		}
+		a.Options = r.dnsOptions
 	default:
 		return nil, fmt.Errorf("unknown .type: %s", r.Type)
 	}
@@ -51,12 +61,19 @@ type measurementType string
 const (
 	measurementTypePing measurementType = "ping"
 	measurementTypeHTTP measurementType = "http"
+	measurementTypeDNS  measurementType = "dns"
 )
 
 type pingOptions struct {
 	PacketsCount uint8 `json:"packets,omitempty"`
 }
 
+type dnsOptions struct {
+	Query struct {
+		Type string `json:"type,omitempty"`
+	} `json:"query"`
+}
+
 type httpOptions struct {
 	Protocol httpProtocol `json:"protocol,omitempty"`
 	Request  struct {
@@ -110,11 +127,18 @@ type measurementResult struct {
 		Status          string            `json:"status"`
 		HTTPHeaders     map[string]string `json:"headers"` // HTTP measurement only
 		ResolvedAddress string            `json:"resolvedAddress"`
+		Answers         []dnsAnswer       `json:"answers"`    // DNS measurement only
 		HTTPStatusCode  uint16            `json:"statusCode"` // HTTP measurement only
+		RawOutput       string            `json:"rawOutput"`  // the error message of failed results
 	} `json:"result"`
-	Probe probe `json:"probe"`
+	Probe probeInfo `json:"probe"`
+}
+
+type dnsAnswer struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
 }
 
-type probe struct {
+type probeInfo struct {
 	Location location `json:"location"`
 }
@@ -0,0 +1,174 @@
+package globalping
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"weibo-image-hound/internal/weibo"
+)
+
+// Option configures a client created by NewClient.
+type Option func(*client)
+
+// WithRawDump makes the client write the raw JSON body of every finished measurement to w.
+func WithRawDump(w io.Writer) Option {
+	return func(c *client) {
+		c.rawDump = w
+	}
+}
+
+// Tracker is notified of the measurements created and finished by the client,
+// so the unfinished ones can be resumed with their IDs after an interruption.
+type Tracker interface {
+	Created(ID string, hostname string)
+	Finished(ID string)
+}
+
+// WithTracker makes the client notify t of the measurements it creates and finishes.
+func WithTracker(t Tracker) Option {
+	return func(c *client) {
+		c.tracker = t
+	}
+}
+
+// WithMaxIdleConns sets the maximum number of idle connections kept in total and per host.
+func WithMaxIdleConns(total int, perHost int) Option {
+	return func(c *client) {
+		c.transport().MaxIdleConns = total
+		c.transport().MaxIdleConnsPerHost = perHost
+	}
+}
+
+// WithMaxConnsPerHost sets the maximum number of connections per host, including in-use ones.
+func WithMaxConnsPerHost(n int) Option {
+	return func(c *client) {
+		c.transport().MaxConnsPerHost = n
+	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection is kept before being closed.
+func WithIdleConnTimeout(d time.Duration) Option {
+	return func(c *client) {
+		c.transport().IdleConnTimeout = d
+	}
+}
+
+// WithPollInterval sets the interval between polls of the in-progress measurements, polled one at a time in turn.
+func WithPollInterval(d time.Duration) Option {
+	return func(c *client) {
+		c.poller.interval = d
+	}
+}
+
+// WithTimeouts sets the timeouts of dialing, the TLS handshake, and receiving the response headers of API requests,
+// zero ones are left as default.
+func WithTimeouts(dial time.Duration, tlsHandshake time.Duration, responseHeader time.Duration) Option {
+	return func(c *client) {
+		if dial > 0 {
+			c.dialer.Timeout = dial
+		}
+		if tlsHandshake > 0 {
+			c.transport().TLSHandshakeTimeout = tlsHandshake
+		}
+		if responseHeader > 0 {
+			c.transport().ResponseHeaderTimeout = responseHeader
+		}
+	}
+}
+
+// WithRequestTimeouts sets the overall timeout of each API request, and how long a measurement is waited for to finish,
+// zero ones are left as default.
+func WithRequestTimeouts(request time.Duration, measurement time.Duration) Option {
+	return func(c *client) {
+		if request > 0 {
+			c.requestTimeout = request
+		}
+		if measurement > 0 {
+			c.measurementTimeout = measurement
+		}
+	}
+}
+
+// WithMaxRetries sets the number of times a GET request failed by a network error or a server error is retried,
+// with a linearly increasing backoff.
+func WithMaxRetries(n int) Option {
+	return func(c *client) {
+		c.maxRetries = n
+	}
+}
+
+// WithCreateRate limits the measurement creations to the given number per minute, evenly spaced.
+func WithCreateRate(perMinute int) Option {
+	return func(c *client) {
+		c.createLimiter = newLimiter(perMinute)
+	}
+}
+
+// WithTag attaches the given label to the created measurements, to identify them later, e.g. for auditing the credit usage.
+func WithTag(tag string) Option {
+	return func(c *client) {
+		c.tag = tag
+	}
+}
+
+// newTransport returns the default tuned transport of the client.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = defaultMaxIdleConns
+	t.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	t.MaxConnsPerHost = defaultMaxConnsPerHost
+	t.IdleConnTimeout = defaultIdleConnTimeout
+	t.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	t.ResponseHeaderTimeout = defaultResponseHeaderTimeout
+	return t
+}
+
+// transport returns the underlying transport of the client.
+func (c *client) transport() *http.Transport {
+	return c.Client.Transport.(*http.Transport)
+}
+
+// WithAPIAddress pins the IP address to connect to for the API hostname, bypassing DNS resolution.
+func WithAPIAddress(IP string) Option {
+	return func(c *client) {
+		c.apiAddress = IP
+	}
+}
+
+// WithResolver makes the client resolve the API hostname with the DNS server at the given address (host:port).
+func WithResolver(address string) Option {
+	return func(c *client) {
+		c.dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			},
+		}
+	}
+}
+
+// dialContext dials the given address with the client's dialer, replacing the host with the pinned API address if any.
+func (c *client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.apiAddress != "" {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addr = net.JoinHostPort(c.apiAddress, port)
+	}
+	return c.dialer.DialContext(ctx, network, addr)
+}
+
+// WithTargets overrides how the given hostnames are resolved.
+func WithTargets(targets map[string]Target) Option {
+	return func(c *client) {
+		c.targets = make(map[string]Target, len(targets))
+		for hostname, t := range targets { // normalized like the hostnames to resolve
+			c.targets[weibo.NormalizeHostname(hostname)] = t
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package globalping
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// poller polls the in-progress measurements of a client one at a time, round-robin at a global interval,
+// so the number of API requests doesn't grow with the number of measurements polled concurrently.
+type poller struct {
+	interval time.Duration
+	poll     func(ID string) ([]measurementResult, bool, error)
+	queue    []*pollRequest
+	running  bool
+	mu       sync.Mutex
+}
+
+// pollRequest is a measurement waiting in the queue of a poller.
+type pollRequest struct {
+	ID        string
+	done      chan pollResult
+	cancelled bool
+}
+
+type pollResult struct {
+	results []measurementResult
+	err     error
+}
+
+// wait queues the measurement of the given ID to be polled until finished or the timeout is reached.
+func (p *poller) wait(ID string, timeout time.Duration) ([]measurementResult, error) {
+	req := &pollRequest{ID: ID, done: make(chan pollResult, 1)}
+	p.mu.Lock()
+	p.queue = append(p.queue, req)
+	if !p.running {
+		p.running = true
+		go p.run()
+	}
+	p.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-req.done:
+		return r.results, r.err
+	case <-t.C:
+		p.mu.Lock()
+		req.cancelled = true
+		for i, q := range p.queue {
+			if q == req {
+				p.queue = append(p.queue[:i], p.queue[i+1:]...)
+				break
+			}
+		}
+		p.mu.Unlock()
+		return nil, fmt.Errorf("timeout")
+	}
+}
+
+// run polls the queued measurements until the queue is empty.
+func (p *poller) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.running = false
+			p.mu.Unlock()
+			return
+		}
+		req := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+
+		results, finished, err := p.poll(req.ID)
+		if finished || err != nil {
+			req.done <- pollResult{results: results, err: err}
+			continue
+		}
+		p.mu.Lock()
+		if !req.cancelled { // back to the end of the queue
+			p.queue = append(p.queue, req)
+		}
+		p.mu.Unlock()
+	}
+}
@@ -4,24 +4,119 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"time"
+
+	"weibo-image-hound/internal/probe"
+	"weibo-image-hound/internal/weibo"
 )
 
 type Config struct {
 	//APIToken string `yaml:"api_token,omitempty"`
+	// APIAddress pins the IP address of the API hostname.
+	APIAddress string `yaml:"api_address,omitempty"`
+	// Resolver is the address (host:port) of the DNS server to resolve the API hostname with.
+	Resolver string `yaml:"resolver,omitempty"`
+	// Targets overrides how hostnames are resolved, by hostname.
+	Targets map[string]Target `yaml:"targets,omitempty"`
+	// DialTimeout, TLSHandshakeTimeout and ResponseHeaderTimeout limit each phase of the API requests,
+	// so a stalled connection fails fast within the overall request timeout.
+	DialTimeout           time.Duration `yaml:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout,omitempty"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout,omitempty"`
+	// MaxIdleConns, MaxConnsPerHost and IdleConnTimeout tune the connection pool to the API,
+	// zero ones are left as default.
+	MaxIdleConns    int           `yaml:"max_idle_conns,omitempty"`
+	MaxConnsPerHost int           `yaml:"max_conns_per_host,omitempty"`
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout,omitempty"`
+	// RequestTimeout is the overall timeout of each API request, defaults to 15 seconds.
+	RequestTimeout time.Duration `yaml:"request_timeout,omitempty"`
+	// MaxRetries is the number of times a GET request failed by a network error or a server error is retried.
+	MaxRetries int `yaml:"max_retries,omitempty"`
+	// MeasurementTimeout is how long a measurement is waited for to finish, defaults to 1 minute.
+	MeasurementTimeout time.Duration `yaml:"measurement_timeout,omitempty"`
+	// PollInterval is the interval between polls of the in-progress measurements, which are polled one at a time in turn.
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+	// CreateRate is the maximum number of measurements created per minute, evenly spaced, 0 for no limit
+	// other than the rate limit of the API.
+	CreateRate int `yaml:"create_rate,omitempty"`
+	// Tag is the label attached to the created measurements, to identify them later.
+	Tag string `yaml:"tag,omitempty"`
+}
+
+// Target specifies how a hostname is resolved.
+type Target struct {
+	// Type is the measurement type, "ping" (default) or "dns" (for hostnames not responding to ICMP).
+	Type string `yaml:"type,omitempty"`
+	// Target is the alternate target to measure instead of the hostname.
+	Target string `yaml:"target,omitempty"`
 }
 
-func NewClient() *client {
-	return &client{
-		Client: &http.Client{},
-		eTags:  make(map[string]string),
+// Options returns the client options of the config.
+func (cfg Config) Options() []Option {
+	var opts []Option
+	if cfg.APIAddress != "" {
+		opts = append(opts, WithAPIAddress(cfg.APIAddress))
+	}
+	if cfg.Resolver != "" {
+		opts = append(opts, WithResolver(cfg.Resolver))
+	}
+	if len(cfg.Targets) > 0 {
+		opts = append(opts, WithTargets(cfg.Targets))
+	}
+	if cfg.PollInterval > 0 {
+		opts = append(opts, WithPollInterval(cfg.PollInterval))
+	}
+	if cfg.RequestTimeout > 0 || cfg.MeasurementTimeout > 0 {
+		opts = append(opts, WithRequestTimeouts(cfg.RequestTimeout, cfg.MeasurementTimeout))
+	}
+	if cfg.MaxRetries > 0 {
+		opts = append(opts, WithMaxRetries(cfg.MaxRetries))
+	}
+	if cfg.CreateRate > 0 {
+		opts = append(opts, WithCreateRate(cfg.CreateRate))
+	}
+	if cfg.Tag != "" {
+		opts = append(opts, WithTag(cfg.Tag))
 	}
+	if cfg.DialTimeout > 0 || cfg.TLSHandshakeTimeout > 0 || cfg.ResponseHeaderTimeout > 0 {
+		opts = append(opts, WithTimeouts(cfg.DialTimeout, cfg.TLSHandshakeTimeout, cfg.ResponseHeaderTimeout))
+	}
+	if cfg.MaxIdleConns > 0 {
+		opts = append(opts, WithMaxIdleConns(cfg.MaxIdleConns, min(cfg.MaxIdleConns, defaultMaxIdleConnsPerHost)))
+	}
+	if cfg.MaxConnsPerHost > 0 {
+		opts = append(opts, WithMaxConnsPerHost(cfg.MaxConnsPerHost))
+	}
+	if cfg.IdleConnTimeout > 0 {
+		opts = append(opts, WithIdleConnTimeout(cfg.IdleConnTimeout))
+	}
+	return opts
 }
 
-func (c *client) Resolve(hostname string, locations []string) ([]net.IP, error) {
+func NewClient(opts ...Option) *client {
+	c := &client{
+		Client:             &http.Client{Transport: newTransport()},
+		eTags:              make(map[string]string),
+		dialer:             &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: 30 * time.Second},
+		requestTimeout:     defaultRequestTimeout,
+		measurementTimeout: defaultMeasurementTimeout,
+	}
+	c.poller = &poller{interval: defaultPollInterval, poll: c.pollMeasurement}
+	c.transport().DialContext = c.dialContext
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Resolve returns the resolved IP addresses of the given hostname from the given locations,
+// with the country and region of the probe each was resolved from.
+func (c *client) Resolve(hostname string, locations []string) ([]probe.ResolvedIP, error) {
 	if len(locations) == 0 { // use all default regions if none specified
 		locations = defaultRegions
 	}
-	mID, err := c.createMeasurement(hostname, locations)
+	mType, target := c.target(hostname)
+	mID, err := c.createMeasurement(mType, target, locations)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create measurement: %w", err)
 	}
@@ -31,13 +126,71 @@ func (c *client) Resolve(hostname string, locations []string) ([]net.IP, error)
 		return nil, fmt.Errorf("failed to get measurement: %w", err)
 	}
 
-	IPs := make([]net.IP, 0, len(mResults))
+	return resolvedOf(mResults), nil
+}
+
+// target returns the measurement type and target to resolve the given hostname with.
+func (c *client) target(hostname string) (measurementType, string) {
+	mType, target := measurementTypePing, hostname
+	if t, ok := c.targets[weibo.NormalizeHostname(hostname)]; ok {
+		if t.Type != "" {
+			mType = measurementType(t.Type)
+		}
+		if t.Target != "" {
+			target = t.Target
+		}
+	}
+	return mType, target
+}
+
+// Plan describes the measurement Resolve would create for the given hostname from the given locations,
+// and returns the maximum number of probes it would use, without creating it.
+func (c *client) Plan(hostname string, locations []string) (string, int) {
+	if len(locations) == 0 {
+		locations = defaultRegions
+	}
+	mType, target := c.target(hostname)
+	return fmt.Sprintf("%s measurement of %s from %d locations, up to %d probes each", mType, target, len(locations), probesPerLocation),
+		len(locations) * probesPerLocation
+}
+
+// Measurement returns the resolved IPs of the existing measurement with the given ID, e.g. one created elsewhere.
+func (c *client) Measurement(ID string) ([]probe.ResolvedIP, error) {
+	mResults, err := c.getMeasurement(ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get measurement: %w", err)
+	}
+	return resolvedOf(mResults), nil
+}
+
+// resolvedOf returns the resolved IPs in the given measurement results, with the country and region of their probes,
+// one for each answer.
+func resolvedOf(mResults []measurementResult) []probe.ResolvedIP {
+	resolved := make([]probe.ResolvedIP, 0, len(mResults))
 	for _, r := range mResults {
+		var from probe.ResolvedIP
+		if country := r.Probe.Location.Country; country != "" {
+			from.Countries = []string{country}
+		}
+		if region := r.Probe.Location.Region; region != "" {
+			from.Regions = []string{region}
+		}
+		for _, a := range r.Result.Answers { // DNS measurements only
+			if a.Type == "A" || a.Type == "AAAA" {
+				if IP := net.ParseIP(a.Value); IP != nil {
+					from.IP = IP
+					resolved = append(resolved, from)
+				}
+			}
+		}
 		if r.Result.ResolvedAddress != "" {
-			IPs = append(IPs, net.ParseIP(r.Result.ResolvedAddress))
+			if IP := net.ParseIP(r.Result.ResolvedAddress); IP != nil {
+				from.IP = IP
+				resolved = append(resolved, from)
+			}
 		}
 	}
-	return IPs, nil
+	return resolved
 }
 
 func (c *client) Probes() ([]string, error) {
@@ -1,10 +1,147 @@
 package probe
 
-import "net"
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
 
 type Provider interface {
-	// Resolve returns the resolved IP addresses of the given hostname from the given locations.
-	Resolve(hostname string, locations []string) ([]net.IP, error)
+	// Resolve returns the resolved IP addresses of the given hostname from the given locations,
+	// annotated with where they were resolved from if the provider tells.
+	Resolve(hostname string, locations []string) ([]ResolvedIP, error)
 	// Locations returns all currently supported locations of the provider.
 	Locations() ([]string, error)
 }
+
+// ResolvedIP is a resolved IP address annotated with where it was resolved from, and what else is known about it.
+// It's (un)marshaled as the plain IP address without annotations, as in the caches of older versions.
+type ResolvedIP struct {
+	IP net.IP `yaml:"ip" json:"ip"`
+	// Countries are the ISO 3166-1 alpha-2 codes of the countries it was resolved from, nil if unknown.
+	Countries []string `yaml:"countries,omitempty,flow" json:"countries,omitempty"`
+	// Regions are the names of the regions it was resolved from, nil if unknown.
+	Regions []string `yaml:"regions,omitempty,flow" json:"regions,omitempty"`
+	// Local is whether it was (also) resolved by the local resolver instead of a provider.
+	Local bool `yaml:"local,omitempty" json:"local,omitempty"`
+	// ASNs are the numbers of the autonomous systems originating it, nil if not looked up.
+	ASNs []uint32 `yaml:"asns,omitempty,flow" json:"asns,omitempty"`
+}
+
+// resolvedIP is ResolvedIP without the custom (un)marshaling.
+type resolvedIP ResolvedIP
+
+// String returns the IP address.
+func (r ResolvedIP) String() string {
+	return r.IP.String()
+}
+
+// Family returns the address family of the IP address, "ipv4" or "ipv6".
+func (r ResolvedIP) Family() string {
+	if r.IP.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// Annotated returns whether anything is known about the IP address other than itself.
+func (r ResolvedIP) Annotated() bool {
+	return len(r.Countries) > 0 || len(r.Regions) > 0 || r.Local || len(r.ASNs) > 0
+}
+
+// Merge adds the annotations of o (of the same IP address) to r.
+func (r *ResolvedIP) Merge(o ResolvedIP) {
+	for _, country := range o.Countries {
+		if !slices.Contains(r.Countries, country) {
+			r.Countries = append(r.Countries, country)
+		}
+	}
+	for _, region := range o.Regions {
+		if !slices.Contains(r.Regions, region) {
+			r.Regions = append(r.Regions, region)
+		}
+	}
+	r.Local = r.Local || o.Local
+	for _, ASN := range o.ASNs {
+		if !slices.Contains(r.ASNs, ASN) {
+			r.ASNs = append(r.ASNs, ASN)
+		}
+	}
+}
+
+func (r ResolvedIP) MarshalYAML() (any, error) {
+	if !r.Annotated() {
+		return r.IP.String(), nil
+	}
+	return resolvedIP(r), nil
+}
+
+func (r *ResolvedIP) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return r.parse(value.Value)
+	}
+	return value.Decode((*resolvedIP)(r))
+}
+
+func (r ResolvedIP) MarshalJSON() ([]byte, error) {
+	if !r.Annotated() {
+		return json.Marshal(r.IP.String())
+	}
+	return json.Marshal(resolvedIP(r))
+}
+
+func (r *ResolvedIP) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		return r.parse(s)
+	}
+	return json.Unmarshal(b, (*resolvedIP)(r))
+}
+
+// parse sets r to the plain IP address s.
+func (r *ResolvedIP) parse(s string) error {
+	IP := net.ParseIP(s)
+	if IP == nil {
+		return fmt.Errorf("invalid IP address: %s", s)
+	}
+	*r = ResolvedIP{IP: IP}
+	return nil
+}
+
+// FromIPs returns the given IP addresses without annotations.
+func FromIPs(IPs []net.IP) []ResolvedIP {
+	r := make([]ResolvedIP, 0, len(IPs))
+	for _, IP := range IPs {
+		r = append(r, ResolvedIP{IP: IP})
+	}
+	return r
+}
+
+// IPs returns the IP addresses of the given resolved IPs.
+func IPs(resolved []ResolvedIP) []net.IP {
+	IPs := make([]net.IP, 0, len(resolved))
+	for _, r := range resolved {
+		IPs = append(IPs, r.IP)
+	}
+	return IPs
+}
+
+// Unique returns the unique IP addresses of the given resolved IPs in order, each with the annotations of all its
+// occurrences merged (see ResolvedIP.Merge).
+func Unique(resolved []ResolvedIP) []ResolvedIP {
+	index := make(map[string]int, len(resolved))
+	r := make([]ResolvedIP, 0, len(resolved))
+	for _, e := range resolved {
+		if i, ok := index[e.IP.String()]; ok {
+			r[i].Merge(e)
+			continue
+		}
+		index[e.IP.String()] = len(r)
+		e.Countries, e.Regions, e.ASNs = slices.Clone(e.Countries), slices.Clone(e.Regions), slices.Clone(e.ASNs) // not shared with the given ones
+		r = append(r, e)
+	}
+	return r
+}
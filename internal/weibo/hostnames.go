@@ -1,5 +1,10 @@
 package weibo
 
+import (
+	"net"
+	"strings"
+)
+
 var (
 	hostnames = []string{
 		"wx1.sinaimg.cn",
@@ -12,3 +17,39 @@ var (
 func Hostnames() []string {
 	return hostnames
 }
+
+// SiblingHostnames returns the known hostnames other than the given one, which serve the same images.
+func SiblingHostnames(hostname string) []string {
+	hostname = NormalizeHostname(hostname)
+	siblings := make([]string, 0, len(hostnames))
+	for _, h := range hostnames {
+		if h != hostname {
+			siblings = append(siblings, h)
+		}
+	}
+	return siblings
+}
+
+// NormalizeHostname returns the given hostname in lowercase without the trailing dot and the port if any,
+// so the variants of the same hostname are never treated as different ones.
+func NormalizeHostname(hostname string) string {
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = h
+	}
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
+
+// cdnDomain is the domain of all Weibo image CDN hostnames.
+const cdnDomain = "sinaimg.cn"
+
+// IsCDNHostname returns whether the given hostname is a Weibo image CDN hostname,
+// and whether it's one of the known hostnames that resolves are cached for.
+func IsCDNHostname(hostname string) (cdn bool, known bool) {
+	hostname = NormalizeHostname(hostname)
+	for _, h := range hostnames {
+		if hostname == h {
+			return true, true
+		}
+	}
+	return strings.HasSuffix(hostname, "."+cdnDomain), false
+}
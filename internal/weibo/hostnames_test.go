@@ -0,0 +1,65 @@
+package weibo
+
+import "testing"
+
+func TestNormalizeHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     string
+	}{
+		{"wx1.sinaimg.cn", "wx1.sinaimg.cn"},
+		{"WX1.SinaImg.CN", "wx1.sinaimg.cn"},
+		{"wx1.sinaimg.cn.", "wx1.sinaimg.cn"},
+		{"wx1.sinaimg.cn:443", "wx1.sinaimg.cn"},
+		{"WX1.SINAIMG.CN.:8443", "wx1.sinaimg.cn"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeHostname(tt.hostname); got != tt.want {
+			t.Errorf("NormalizeHostname(%q) = %q, want %q", tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestIsCDNHostname(t *testing.T) {
+	tests := []struct {
+		hostname   string
+		cdn, known bool
+	}{
+		{"wx1.sinaimg.cn", true, true},
+		{"WX2.SINAIMG.CN.", true, true},
+		{"wx3.sinaimg.cn:80", true, true},
+		{"tvax1.sinaimg.cn", true, false},
+		{"sinaimg.cn.example.com", false, false},
+	}
+	for _, tt := range tests {
+		if cdn, known := IsCDNHostname(tt.hostname); cdn != tt.cdn || known != tt.known {
+			t.Errorf("IsCDNHostname(%q) = %t, %t, want %t, %t", tt.hostname, cdn, known, tt.cdn, tt.known)
+		}
+	}
+}
+
+func TestGenerateURLsOfAllQualitiesHostnames(t *testing.T) {
+	const want = "https://wx1.sinaimg.cn/mw2000/0075tcm0gy1hxxxxxxxxxj30u0140tcm.jpg"
+	for _, URL := range []string{
+		"https://wx1.sinaimg.cn/large/0075tcm0gy1hxxxxxxxxxj30u0140tcm.jpg",
+		"https://WX1.SINAIMG.CN/large/0075tcm0gy1hxxxxxxxxxj30u0140tcm.jpg",
+		"https://wx1.sinaimg.cn./large/0075tcm0gy1hxxxxxxxxxj30u0140tcm.jpg",
+		"https://wx1.sinaimg.cn:443/large/0075tcm0gy1hxxxxxxxxxj30u0140tcm.jpg",
+		"https://Wx1.Sinaimg.Cn.:8443/large/0075tcm0gy1hxxxxxxxxxj30u0140tcm.jpg",
+		"wx1.sinaimg.cn/large/0075tcm0gy1hxxxxxxxxxj30u0140tcm.jpg",
+	} {
+		if m := patternImageURL.FindStringSubmatch(URL); m == nil {
+			t.Errorf("patternImageURL doesn't match %q", URL)
+		} else if got := NormalizeHostname(m[1]); got != "wx1.sinaimg.cn" {
+			t.Errorf("patternImageURL hostname of %q = %q, want %q", URL, got, "wx1.sinaimg.cn")
+		}
+		URLs, err := GenerateURLsOfAllQualities(URL)
+		if err != nil {
+			t.Errorf("GenerateURLsOfAllQualities(%q) error: %v", URL, err)
+			continue
+		}
+		if len(URLs) != len(qualities) || URLs[0] != want {
+			t.Errorf("GenerateURLsOfAllQualities(%q) = %v, want %d URLs from %q", URL, URLs, len(qualities), want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package weibo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// placeholderHashes are the built-in SHA-256 hashes (in hex) of bodies known to be placeholders instead of the image.
+var placeholderHashes = []string{
+	"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", // empty body
+}
+
+// IsPlaceholder returns whether the given body is a known placeholder,
+// by its SHA-256 hash among the built-in ones and the given extra ones (in hex).
+func IsPlaceholder(body []byte, extraHashes []string) bool {
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	for _, h := range placeholderHashes {
+		if h == hash {
+			return true
+		}
+	}
+	for _, h := range extraHashes {
+		if strings.EqualFold(strings.TrimSpace(h), hash) {
+			return true
+		}
+	}
+	return false
+}
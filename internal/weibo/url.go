@@ -1,24 +1,139 @@
 package weibo
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 	"regexp"
+	"strings"
+	"unicode"
 )
 
 var (
-	patternImageURL = regexp.MustCompile(`(?:https?://)?([\da-zA-Z\-.]+\.sinaimg\.cn)/.+/([\da-zA-Z]+\.(?:jpg|png|gif))`)
+	patternImageURL = regexp.MustCompile(`(?i)(?:https?://)?([\da-z\-.]+\.sinaimg\.cn)\.?(?::\d+)?/.+/([\da-z]+\.(?:jpg|png|gif))`)
+	patternImageID  = regexp.MustCompile(`(?i)(?:^|[^\da-z])([\da-z]{32})(?:[^\da-z]|$)`)
 	qualities       = []string{"mw2000", "woriginal", "large", "orj1080", "mw1024", "orj960", "sti960", "wapb720", "mw690", "orj480", "bmiddle", "wap360", "thumbnail", "thumb180", "wap180", "small", "square"}
 )
 
 func GenerateURLsOfAllQualities(URL string) ([]string, error) {
+	ch, err := GenerateURLs(context.Background(), URL)
+	if err != nil {
+		return nil, err
+	}
+
+	URLs := make([]string, 0, len(qualities))
+	for u := range ch {
+		URLs = append(URLs, u)
+	}
+	return URLs, nil
+}
+
+// GenerateURLs returns a channel streaming the URLs of all qualities of the given Weibo image URL,
+// from the highest quality to the lowest. The channel is closed after the last URL, or when ctx is done.
+func GenerateURLs(ctx context.Context, URL string) (<-chan string, error) {
 	m := patternImageURL.FindStringSubmatch(URL)
 	if len(m) != 3 || m[1] == "" || m[2] == "" {
-		return nil, fmt.Errorf("invalid Weibo image URL")
+		hostname, filename, ok := parseLenient(URL)
+		if !ok {
+			return nil, fmt.Errorf("invalid Weibo image URL")
+		}
+		m = []string{URL, hostname, filename}
+	}
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for _, q := range qualities {
+			select {
+			case ch <- fmt.Sprintf("https://%s/%s/%s", NormalizeHostname(m[1]), q, m[2]):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// ExtractImageURLs returns the Weibo image URLs in the given text, e.g. an HTML snippet of a Weibo page,
+// including the ones with escaped slashes and protocol-relative ones common in embedded JSON.
+// They are returned in order with the https scheme, only the first one of the same image (in any quality).
+func ExtractImageURLs(text string) []string {
+	text = strings.NewReplacer(`\/`, "/", `\u002F`, "/", `\u002f`, "/").Replace(text)
+	tokens := strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`"'<>()[]{},;\`, r)
+	})
+	var URLs []string
+	seen := make(map[string]struct{})
+	for _, t := range tokens {
+		m := patternImageURL.FindStringSubmatchIndex(t)
+		if m == nil {
+			continue
+		}
+		filename := strings.ToLower(t[m[4]:m[5]])
+		if _, ok := seen[filename]; ok {
+			continue
+		}
+		seen[filename] = struct{}{}
+		URLs = append(URLs, "https://"+t[m[2]:m[1]]) // from the hostname
+	}
+	return URLs
+}
+
+// parseLenient extracts the hostname and the filename (the last path segment) of a Weibo image URL with an unusual path,
+// e.g. with no quality segment, more segments, or an unknown extension, which the strict pattern doesn't match.
+func parseLenient(URL string) (hostname string, filename string, ok bool) {
+	if strings.HasPrefix(URL, "//") {
+		URL = "https:" + URL
+	} else if !strings.Contains(URL, "://") {
+		URL = "https://" + URL
 	}
+	u, err := url.Parse(URL)
+	if err != nil {
+		return "", "", false
+	}
+	hostname = NormalizeHostname(u.Hostname())
+	if cdn, _ := IsCDNHostname(hostname); !cdn {
+		return "", "", false
+	}
+	filename = u.Path[strings.LastIndex(u.Path, "/")+1:]
+	if filename == "" {
+		return "", "", false
+	}
+	return hostname, filename, true
+}
 
-	URLs := make([]string, len(qualities))
+// Qualities returns all known image quality tokens, from the highest to the lowest.
+func Qualities() []string {
+	return append([]string(nil), qualities...)
+}
+
+// QualityRank returns the rank of the given quality token among all known ones, 0 for the highest, or -1 if unknown.
+// A quality is higher than another by the difference of their ranks in tiers.
+func QualityRank(quality string) int {
 	for i, q := range qualities {
-		URLs[i] = fmt.Sprintf("https://%s/%s/%s", m[1], q, m[2])
+		if q == quality {
+			return i
+		}
 	}
-	return URLs, nil
+	return -1
+}
+
+// QualityOf returns the quality token in the given Weibo image URL, or an empty string if unknown.
+func QualityOf(URL string) string {
+	for _, q := range qualities {
+		if strings.Contains(URL, "/"+q+"/") {
+			return q
+		}
+	}
+	return ""
+}
+
+// ImageID returns the ID of the Weibo image in the given URL or filename (e.g. of a saved image),
+// which is the same across all qualities, or an empty string if not found.
+func ImageID(s string) string {
+	s = s[strings.LastIndex(s, "/")+1:]
+	if m := patternImageID.FindStringSubmatch(s); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
 }
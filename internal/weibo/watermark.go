@@ -0,0 +1,64 @@
+package weibo
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"strings"
+)
+
+const (
+	// size of the bottom-right corner region (in percent of the image size) where Weibo places its watermark
+	watermarkRegionWidthPercent  = 30
+	watermarkRegionHeightPercent = 10
+	// minimum brightness (of 0xffff) of a pixel to be considered as part of a watermark
+	watermarkBrightness = 0xe000
+)
+
+// DetectWatermark heuristically checks whether the given image response is likely watermarked.
+// It returns whether a watermark is suspected, and the reason for the suspicion.
+func DetectWatermark(headers http.Header, body []byte) (bool, string) {
+	for k, v := range headers {
+		if strings.Contains(strings.ToLower(k), "watermark") {
+			return true, "header " + k + ": " + strings.Join(v, ", ")
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return false, ""
+	}
+	b := img.Bounds()
+	w, h := b.Dx()*watermarkRegionWidthPercent/100, b.Dy()*watermarkRegionHeightPercent/100
+	if w == 0 || h == 0 {
+		return false, ""
+	}
+	region := image.Rect(b.Max.X-w, b.Max.Y-h, b.Max.X, b.Max.Y)
+	inRegion, total := brightRatio(img, region), brightRatio(img, b)
+	// watermark text is bright, so the corner is notably brighter than the rest of the image
+	if inRegion > 0.02 && inRegion > total*2 {
+		return true, "bright text-like pixels in the bottom-right corner"
+	}
+	return false, ""
+}
+
+// brightRatio returns the ratio of bright pixels in the given region of the image, sampling every other pixel.
+func brightRatio(img image.Image, region image.Rectangle) float64 {
+	var bright, total int
+	for y := region.Min.Y; y < region.Max.Y; y += 2 {
+		for x := region.Min.X; x < region.Max.X; x += 2 {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if r >= watermarkBrightness && g >= watermarkBrightness && b >= watermarkBrightness {
+				bright++
+			}
+			total++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(bright) / float64(total)
+}